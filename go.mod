@@ -8,9 +8,11 @@ require (
 	github.com/kuttiproject/driver-vbox v0.4.0
 	github.com/kuttiproject/kuttilib v0.5.0
 	github.com/kuttiproject/kuttilog v0.2.1
-	github.com/kuttiproject/sshclient v0.2.1
 	github.com/kuttiproject/workspace v0.3.1
 	github.com/spf13/cobra v1.9.1
+	golang.org/x/crypto v0.39.0
+	golang.org/x/term v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,11 +20,10 @@ require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/kuttiproject/drivercore v0.3.1 // indirect
+	github.com/kuttiproject/sshclient v0.2.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/povsister/scp v0.0.0-20250701154629-777cf82de5df // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
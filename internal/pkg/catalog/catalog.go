@@ -0,0 +1,249 @@
+// Package catalog manages version catalogs, which list the images of the
+// Kubernetes versions of a driver, with their locations and checksums.
+// Catalogs let kutti download images from a mirror instead of the public
+// sources used by drivers.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kuttiproject/workspace"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	"github.com/kuttiproject/kutti/internal/pkg/download"
+)
+
+const (
+	// Catalogs in use are saved in this workspace directory, one per
+	// driver.
+	catalogsdirname = "catalogs"
+	// Per-driver setting for the catalog location, as
+	// catalog-<drivername>.
+	catalogsettingprefix = "catalog-"
+)
+
+// Entry is the image of a Kubernetes version in a catalog.
+type Entry struct {
+	K8sVersion string
+	// ImageURL is a URL, or a file path for catalogs loaded from files.
+	// It can be relative to the location of the catalog.
+	ImageURL   string
+	SHA256     string
+	Deprecated bool
+}
+
+// Catalog lists the images of the Kubernetes versions of a driver.
+type Catalog struct {
+	Driver   string
+	Versions []*Entry
+}
+
+// Get returns the entry of a Kubernetes version.
+func (c *Catalog) Get(k8sversion string) (*Entry, bool) {
+	for _, entry := range c.Versions {
+		if entry.K8sVersion == k8sversion {
+			return entry, true
+		}
+	}
+
+	return nil, false
+}
+
+// IsURL reports whether a location is an HTTP or HTTPS URL, as opposed to
+// a file path.
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "http://") ||
+		strings.HasPrefix(location, "https://")
+}
+
+// SettingName returns the name of the setting for the catalog location of
+// a driver.
+func SettingName(drivername string) string {
+	return catalogsettingprefix + drivername
+}
+
+// Location returns the catalog location for a driver. The override is
+// returned if not empty. Otherwise, the value of the setting returned by
+// SettingName is returned, which may be empty.
+func Location(drivername string, override string) string {
+	if override != "" {
+		return override
+	}
+
+	location, _ := cli.Setting(SettingName(drivername))
+	return location
+}
+
+// Load reads a catalog from a URL or a file path, and checks it. Image
+// locations are resolved against the catalog location.
+func Load(location string) (*Catalog, error) {
+	data, err := read(location)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Catalog{}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog %v: %v", location, err)
+	}
+
+	if result.Driver == "" {
+		return nil, fmt.Errorf("invalid catalog %v: no driver specified", location)
+	}
+
+	for _, entry := range result.Versions {
+		if entry.K8sVersion == "" || entry.ImageURL == "" {
+			return nil, fmt.Errorf(
+				"invalid catalog %v: every version needs a K8sVersion and an ImageURL",
+				location,
+			)
+		}
+
+		entry.SHA256, err = download.NormalizeSHA256(entry.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid catalog %v: version %v: %v",
+				location,
+				entry.K8sVersion,
+				err,
+			)
+		}
+
+		entry.ImageURL, err = resolve(location, entry.ImageURL)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid catalog %v: version %v: %v",
+				location,
+				entry.K8sVersion,
+				err,
+			)
+		}
+	}
+
+	return result, nil
+}
+
+func read(location string) ([]byte, error) {
+	if !IsURL(location) {
+		return os.ReadFile(location)
+	}
+
+	response, err := http.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get catalog %v: server returned %v", location, response.Status)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// resolve returns an image location relative to a catalog location as an
+// absolute URL or file path.
+func resolve(location string, imageurl string) (string, error) {
+	if IsURL(imageurl) {
+		return imageurl, nil
+	}
+
+	if IsURL(location) {
+		base, err := url.Parse(location)
+		if err != nil {
+			return "", err
+		}
+
+		reference, err := url.Parse(imageurl)
+		if err != nil {
+			return "", err
+		}
+
+		return base.ResolveReference(reference).String(), nil
+	}
+
+	if filepath.IsAbs(imageurl) {
+		return imageurl, nil
+	}
+
+	return filepath.Abs(filepath.Join(filepath.Dir(location), imageurl))
+}
+
+func savedPath(drivername string) (string, error) {
+	catalogsdir, err := workspace.Configsubdir(catalogsdirname)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(catalogsdir, drivername+".json"), nil
+}
+
+// Save saves a catalog loaded by Load as the catalog of a driver, for use
+// by Saved. The catalog must be for the driver.
+func Save(drivername string, c *Catalog) error {
+	if c.Driver != drivername {
+		return fmt.Errorf(
+			"catalog is for driver '%v', not '%v'",
+			c.Driver,
+			drivername,
+		)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := savedPath(drivername)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// Saved returns the catalog of a driver saved by Save. If there is none,
+// the error matches os.ErrNotExist.
+func Saved(drivername string) (*Catalog, error) {
+	path, err := savedPath(drivername)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Catalog{}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid saved catalog %v: %v", path, err)
+	}
+
+	return result, nil
+}
+
+// Remove deletes the saved catalog of a driver, if any.
+func Remove(drivername string) error {
+	path, err := savedPath(drivername)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,74 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	checksum := strings.Repeat("ab", sha256.Size)
+	dir := t.TempDir()
+
+	testCases := []struct {
+		name        string
+		content     string
+		expectedurl string
+		expectederr string
+	}{
+		{
+			name:        "relative",
+			content:     `{"Driver":"vbox","Versions":[{"K8sVersion":"1.29","ImageURL":"images/kutti-1.29.ova","SHA256":"` + checksum + `"}]}`,
+			expectedurl: filepath.Join(dir, "images", "kutti-1.29.ova"),
+		},
+		{
+			name:        "absolute",
+			content:     `{"Driver":"vbox","Versions":[{"K8sVersion":"1.29","ImageURL":"https://mirror/kutti-1.29.ova","SHA256":"sha256:` + strings.ToUpper(checksum) + `"}]}`,
+			expectedurl: "https://mirror/kutti-1.29.ova",
+		},
+		{
+			name:        "no driver",
+			content:     `{"Versions":[]}`,
+			expectederr: "no driver",
+		},
+		{
+			name:        "bad checksum",
+			content:     `{"Driver":"vbox","Versions":[{"K8sVersion":"1.29","ImageURL":"kutti-1.29.ova","SHA256":"abc"}]}`,
+			expectederr: "invalid SHA-256",
+		},
+		{
+			name:        "no image",
+			content:     `{"Driver":"vbox","Versions":[{"K8sVersion":"1.29","SHA256":"` + checksum + `"}]}`,
+			expectederr: "ImageURL",
+		},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(dir, "catalog.json")
+		os.WriteFile(path, []byte(tc.content), 0644)
+
+		result, err := Load(path)
+		if tc.expectederr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectederr) {
+				t.Fatalf("%v: expected error containing '%v', got %v", tc.name, tc.expectederr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+
+		entry, ok := result.Get("1.29")
+		if !ok {
+			t.Fatalf("%v: version 1.29 not found", tc.name)
+		}
+		if entry.ImageURL != tc.expectedurl {
+			t.Fatalf("%v: expected image URL %v, got %v", tc.name, tc.expectedurl, entry.ImageURL)
+		}
+		if entry.SHA256 != checksum {
+			t.Fatalf("%v: checksum not normalized: %v", tc.name, entry.SHA256)
+		}
+	}
+}
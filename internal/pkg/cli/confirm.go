@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Confirm asks a yes/no question on the standard output, and reads the
+// answer from the standard input. Anything other than "y" or "yes",
+// including end of input, is taken as no.
+func Confirm(question string) bool {
+	fmt.Printf("%v [y/N]: ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
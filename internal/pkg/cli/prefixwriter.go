@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter writes each line written to it to an underlying writer,
+// preceded by a prefix. Several PrefixWriters can share an underlying
+// writer and a mutex, so that lines from different sources do not mix.
+type PrefixWriter struct {
+	out     io.Writer
+	prefix  []byte
+	mutex   *sync.Mutex
+	pending []byte
+}
+
+// Write buffers any incomplete last line until more data, or a Flush.
+func (p *PrefixWriter) Write(data []byte) (int, error) {
+	p.pending = append(p.pending, data...)
+
+	for {
+		index := bytes.IndexByte(p.pending, '\n')
+		if index < 0 {
+			break
+		}
+
+		err := p.writeline(p.pending[:index+1])
+		p.pending = p.pending[index+1:]
+		if err != nil {
+			return len(data), err
+		}
+	}
+
+	return len(data), nil
+}
+
+// Flush writes any incomplete last line, followed by a newline.
+func (p *PrefixWriter) Flush() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+
+	line := append(p.pending, '\n')
+	p.pending = nil
+	return p.writeline(line)
+}
+
+func (p *PrefixWriter) writeline(line []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, err := p.out.Write(append(append([]byte{}, p.prefix...), line...))
+	return err
+}
+
+// NewPrefixWriter returns a new PrefixWriter, which writes lines to out
+// preceded by prefix, holding mutex while writing each line.
+func NewPrefixWriter(out io.Writer, prefix string, mutex *sync.Mutex) *PrefixWriter {
+	return &PrefixWriter{
+		out:    out,
+		prefix: []byte(prefix),
+		mutex:  mutex,
+	}
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/kuttiproject/workspace"
 )
@@ -73,11 +74,71 @@ func RemoveDefault(name string) error {
 	return RemoveSetting(settingnamefordefault(name))
 }
 
+func settingnameforcluster(clustername string, name string) string {
+	return "cluster-" + clustername + "-" + name
+}
+
+// ClusterSetting gets the value of a setting called
+// cluster-<clustername>-<name>.
+func ClusterSetting(clustername string, name string) (string, bool) {
+	return Setting(settingnameforcluster(clustername, name))
+}
+
+// SetClusterSetting sets a setting called cluster-<clustername>-<name>
+// to the specified value.
+func SetClusterSetting(clustername string, name string, value string) error {
+	return SetSetting(settingnameforcluster(clustername, name), value)
+}
+
+// RemoveClusterSetting deletes a setting called
+// cluster-<clustername>-<name>.
+// If the setting does not exist, nothing happens.
+func RemoveClusterSetting(clustername string, name string) error {
+	return RemoveSetting(settingnameforcluster(clustername, name))
+}
+
+// RemoveClusterSettings deletes all settings of the specified cluster.
+// Cluster names cannot contain hyphens, so the prefix cannot match the
+// settings of another cluster.
+func RemoveClusterSettings(clustername string) error {
+	prefix := settingnameforcluster(clustername, "")
+	for name := range data.settings {
+		if strings.HasPrefix(name, prefix) {
+			delete(data.settings, name)
+		}
+	}
+
+	return settingmanager.Save()
+}
+
 // Settings returns the settings map.
 func Settings() map[string]string {
 	return data.settings
 }
 
+// secretsettingsuffix ends the names of settings that hold secrets, like
+// ssh-password and cluster-<clustername>-ssh-password.
+const secretsettingsuffix = "password"
+
+// maskedvalue is shown instead of the value of a secret setting.
+const maskedvalue = "********"
+
+// IsSecretSetting reports whether the specified setting holds a secret,
+// whose value should not be shown.
+func IsSecretSetting(name string) bool {
+	return strings.HasSuffix(name, secretsettingsuffix)
+}
+
+// MaskSetting returns the value of a setting for display. The values of
+// secret settings are masked, unless they are empty.
+func MaskSetting(name string, value string) string {
+	if value == "" || !IsSecretSetting(name) {
+		return value
+	}
+
+	return maskedvalue
+}
+
 func init() {
 	data = &settingdata{
 		settings: map[string]string{},
@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilib"
+	"github.com/kuttiproject/kuttilog"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
+)
+
+const (
+	// Credentials baked into kutti node images.
+	defaultSSHUsername = "user1"
+	defaultSSHPassword = "Pass@word1"
+
+	// Per-cluster setting that names the control plane node of a
+	// managed cluster.
+	controlplanesetting = "controlplane"
+
+	controlplanenodename = "control"
+	defaultnamepattern   = "worker%d"
+	defaultworkercount   = 2
+	defaultsshportbase   = 10022
+
+	podnetworkcidr  = "10.244.0.0/16"
+	sshwaittimeout  = 5 * time.Minute
+	sshwaitinterval = 5 * time.Second
+)
+
+// runOnNode runs a command over SSH, and returns the output lines.
+func runOnNode(client *remote.Client, node *kuttilib.Node, command string) ([]string, error) {
+	address := node.SSHAddress()
+	if address == "" {
+		return nil, fmt.Errorf("could not fetch SSH address for node '%v'", node.Name())
+	}
+
+	kuttilog.Printf(kuttilog.Debug, "Running on node %v: %v", node.Name(), client.Redact(command))
+	return client.RunWithResults(address, command)
+}
+
+// waitForSSH polls a node until it accepts SSH connections, or the
+// timeout expires.
+func waitForSSH(client *remote.Client, node *kuttilib.Node, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := runOnNode(client, node, "true")
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node '%v' did not respond over SSH within %v: %v", node.Name(), timeout, err)
+		}
+
+		time.Sleep(sshwaitinterval)
+	}
+}
+
+// createAndStartNode creates a node, forwards its SSH port if the driver
+// requires it, starts it and waits until it can be reached over SSH.
+func createAndStartNode(cluster *kuttilib.Cluster, client *remote.Client, nodename string, sshport int) (*kuttilib.Node, error) {
+	kuttilog.Printf(kuttilog.Info, "Creating node '%v'...", nodename)
+	node, err := cluster.NewUninitializedNode(nodename)
+	if err != nil {
+		return nil, fmt.Errorf("could not create node '%v': %v", nodename, err)
+	}
+
+	if cluster.Driver().UsesNATNetworking() {
+		err = node.ForwardSSHPort(sshport)
+		if err != nil {
+			return node, fmt.Errorf("could not forward SSH port of node '%v' to host port %v: %v", nodename, sshport, err)
+		}
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Starting node '%v'...", nodename)
+	err = node.Start()
+	if err != nil {
+		return node, fmt.Errorf("could not start node '%v': %v", nodename, err)
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Waiting for node '%v' to accept SSH connections...", nodename)
+	err = waitForSSH(client, node, sshwaittimeout)
+	if err != nil {
+		return node, err
+	}
+
+	_, err = runOnNode(
+		client,
+		node,
+		client.Sudo("hostnamectl set-hostname "+nodename),
+	)
+	if err != nil {
+		return node, fmt.Errorf("could not set hostname of node '%v': %v", nodename, err)
+	}
+
+	return node, nil
+}
+
+// initControlPlane runs kubeadm init on the control plane node, sets up
+// kubectl access for the SSH user, installs the pod network add-on and
+// returns the join command for workers.
+func initControlPlane(client *remote.Client, node *kuttilib.Node) (string, error) {
+	kuttilog.Printf(kuttilog.Info, "Initializing control plane on node '%v'...", node.Name())
+
+	initcommand := fmt.Sprintf(
+		"kubeadm init --node-name %v --pod-network-cidr %v --apiserver-advertise-address %v --apiserver-cert-extra-sans 127.0.0.1,localhost",
+		node.Name(),
+		podnetworkcidr,
+		node.IPAddress(),
+	)
+	_, err := runOnNode(client, node, client.Sudo(initcommand))
+	if err != nil {
+		return "", fmt.Errorf("kubeadm init failed on node '%v': %v", node.Name(), err)
+	}
+
+	_, err = runOnNode(
+		client,
+		node,
+		"mkdir -p $HOME/.kube && "+
+			client.Sudo("cp -f /etc/kubernetes/admin.conf $HOME/.kube/config")+
+			" && "+
+			client.Sudo("chown $(id -u):$(id -g) $HOME/.kube/config"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not set up kubectl access on node '%v': %v", node.Name(), err)
+	}
+
+	manifesturl := cniManifestURL(node.Cluster().K8sVersion())
+	kuttilog.Printf(kuttilog.Info, "Installing pod network add-on from %v...", manifesturl)
+	_, err = runOnNode(client, node, "kubectl apply -f "+remote.ShellQuote(manifesturl))
+	if err != nil {
+		return "", fmt.Errorf("could not install pod network add-on: %v", err)
+	}
+
+	return joinCommand(client, node)
+}
+
+// joinCommand creates a bootstrap token on the control plane node, and
+// returns the command that workers should use to join the cluster.
+func joinCommand(client *remote.Client, node *kuttilib.Node) (string, error) {
+	output, err := runOnNode(
+		client,
+		node,
+		client.Sudo("kubeadm token create --print-join-command"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not create join command: %v", err)
+	}
+
+	for _, line := range output {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "kubeadm join") {
+			return line, nil
+		}
+	}
+
+	return "", fmt.Errorf("kubeadm did not print a join command")
+}
+
+// joinWorker runs the kubeadm join command on a worker node.
+func joinWorker(client *remote.Client, node *kuttilib.Node, joincommand string) error {
+	kuttilog.Printf(kuttilog.Info, "Joining node '%v' to the cluster...", node.Name())
+
+	_, err := runOnNode(
+		client,
+		node,
+		client.Sudo(joincommand+" --node-name "+node.Name()),
+	)
+	if err != nil {
+		return fmt.Errorf("kubeadm join failed on node '%v': %v", node.Name(), err)
+	}
+
+	return nil
+}
+
+// removeWorker removes a worker node from Kubernetes, by running kubectl
+// on the control plane node.
+func removeWorker(client *remote.Client, controlplane *kuttilib.Node, nodename string) error {
+	_, err := runOnNode(
+		client,
+		controlplane,
+		fmt.Sprintf("kubectl delete node %v --ignore-not-found", nodename),
+	)
+	return err
+}
+
+// bootstrapCluster creates and starts the nodes of a newly created managed
+// cluster, initializes the control plane and joins the workers.
+func bootstrapCluster(cluster *kuttilib.Cluster, nodenames []string, sshports []int) error {
+	client, err := newClusterClient(nil, cluster.Name())
+	if err != nil {
+		return fmt.Errorf("could not set up SSH key: %v", err)
+	}
+
+	nodes := make([]*kuttilib.Node, 0, len(nodenames))
+	for i, nodename := range nodenames {
+		node, err := createAndStartNode(cluster, client, nodename, sshports[i])
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+	}
+
+	controlplane := nodes[0]
+	joincommand, err := initControlPlane(client, controlplane)
+	if err != nil {
+		return err
+	}
+
+	err = cli.SetClusterSetting(cluster.Name(), controlplanesetting, controlplane.Name())
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes[1:] {
+		err = joinWorker(client, node, joincommand)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+)
+
+const (
+	// Global setting for the URL of the pod network manifest applied by
+	// bootstrap. It overrides the pinned Flannel release, for example to
+	// use an internal mirror.
+	cnimanifestsetting = "cni-manifest"
+
+	flannelmanifesturl = "https://github.com/flannel-io/flannel/releases/download/%v/kube-flannel.yml"
+)
+
+// flannelreleases pins the Flannel release applied for Kubernetes minor
+// versions, oldest first. Each release is used from its minor version up
+// to the next entry.
+var flannelreleases = []struct {
+	minor   int
+	release string
+}{
+	{minor: 24, release: "v0.22.3"},
+	{minor: 27, release: "v0.24.4"},
+	{minor: 29, release: "v0.25.7"},
+	{minor: 31, release: "v0.26.1"},
+}
+
+// k8sMinorVersion returns the minor version of a Kubernetes version string
+// such as 1.29 or v1.29.3, or -1 if it cannot be parsed.
+func k8sMinorVersion(k8sversion string) int {
+	parts := strings.Split(strings.TrimPrefix(k8sversion, "v"), ".")
+	if len(parts) < 2 {
+		return -1
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return -1
+	}
+
+	return minor
+}
+
+// cniManifestURL returns the URL of the pod network manifest for a
+// Kubernetes version: the cni-manifest setting if set, or else the
+// manifest of the Flannel release pinned for the version.
+func cniManifestURL(k8sversion string) string {
+	if value, ok := cli.Setting(cnimanifestsetting); ok && value != "" {
+		return value
+	}
+
+	return fmt.Sprintf(flannelmanifesturl, flannelRelease(k8sversion))
+}
+
+// flannelRelease returns the Flannel release pinned for a Kubernetes
+// version. Versions older than the table get the oldest release.
+func flannelRelease(k8sversion string) string {
+	minor := k8sMinorVersion(k8sversion)
+
+	release := flannelreleases[0].release
+	for _, entry := range flannelreleases {
+		if minor >= entry.minor {
+			release = entry.release
+		}
+	}
+
+	return release
+}
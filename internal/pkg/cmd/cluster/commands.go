@@ -74,9 +74,30 @@ var clusterCmd = &cli.Command{
 		},
 		{
 			Cmd: &cobra.Command{
-				Use:           "create CLUSTERNAME",
-				Aliases:       []string{"add"},
-				Short:         "Create a new cluster",
+				Use:     "create CLUSTERNAME",
+				Aliases: []string{"add"},
+				Short:   "Create a new cluster",
+				Long: `
+Create a new cluster.
+
+By default, a managed cluster is created. A control plane node is created
+and initialized using kubeadm, and worker nodes are created and joined to
+it. Use --unmanaged to create an empty cluster, and add nodes yourself.
+
+The Flannel pod network add-on is installed from a Flannel release pinned
+for the Kubernetes version of the cluster. To install it from elsewhere,
+such as an internal mirror, set the cni-manifest setting to the URL of the
+manifest.
+
+For drivers that use NAT networking, free host ports are found for each
+node's SSH port, starting at --sshport. If any node cannot be created, the
+whole cluster is removed.
+
+Examples:
+	kutti cluster create dev
+	kutti cluster create dev --workers 3 --name-pattern node%d
+	kutti cluster create lab --unmanaged
+`,
 				Args:          cobra.ExactArgs(1),
 				RunE:          clusterCreateCommand,
 				SilenceErrors: true,
@@ -94,6 +115,34 @@ var clusterCmd = &cli.Command{
 					"create an unmanaged cluster with no nodes",
 				)
 
+				c.Flags().IntP(
+					"workers",
+					"w",
+					defaultworkercount,
+					"number of worker nodes in a managed cluster",
+				)
+
+				c.Flags().IntP(
+					"nodes",
+					"n",
+					defaultworkercount+1,
+					"total number of nodes, including the control plane, in a managed cluster",
+				)
+				c.MarkFlagsMutuallyExclusive("workers", "nodes")
+
+				c.Flags().String(
+					"name-pattern",
+					defaultnamepattern,
+					"pattern for worker node names. %d is replaced by the worker number",
+				)
+
+				c.Flags().IntP(
+					"sshport",
+					"p",
+					defaultsshportbase,
+					"first host port to try when forwarding node SSH ports",
+				)
+
 				c.Flags().BoolP(
 					"select",
 					"s",
@@ -113,6 +162,9 @@ var clusterCmd = &cli.Command{
 				SilenceErrors:         true,
 				DisableFlagsInUseLine: true,
 			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().IntP("parallel", "P", defaultparallelism, "maximum number of nodes to start at once")
+			},
 		},
 		{
 			Cmd: &cobra.Command{
@@ -125,6 +177,303 @@ var clusterCmd = &cli.Command{
 				SilenceErrors:         true,
 				DisableFlagsInUseLine: true,
 			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().IntP("parallel", "P", defaultparallelism, "maximum number of nodes to stop at once")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "apply -f FILENAME",
+				Short: "Create or update a cluster from a spec file",
+				Long: `
+Create or update a cluster from a spec file.
+
+The spec file, in YAML or JSON format, describes the driver, Kubernetes
+version, nodes, SSH ports and published ports of a cluster. Nodes are
+created, deleted, published and unpublished until the cluster matches the
+spec. Use --dry-run to see the actions without taking them.
+
+Example spec:
+	name: dev
+	driver: vbox
+	k8sversion: "1.30"
+	managed: true
+	nodes:
+	  - name: control
+	    controlplane: true
+	    sshport: 10022
+	    ports:
+	      - nodeport: 6443
+	        hostport: 16443
+	  - name: worker1
+`,
+				Args:          cobra.NoArgs,
+				RunE:          clusterApplyCommand,
+				SilenceErrors: true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().StringP("file", "f", "", "cluster spec file")
+				c.MarkFlagFilename("file", "yaml", "yml", "json")
+				c.MarkFlagRequired("file")
+
+				c.Flags().Bool("dry-run", false, "show the plan, but do not apply it")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:               "export-spec CLUSTERNAME",
+				Args:              cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+				ValidArgsFunction: NameValidArgs,
+				Short:             "Generate a spec file from a cluster",
+				RunE:              clusterExportSpecCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().StringP("output", "o", "yaml", "output format (yaml, json)")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "kubeconfig [CLUSTERNAME]",
+				Short: "Add cluster credentials to kubeconfig",
+				Long: `
+Add cluster credentials to kubeconfig.
+
+The admin kubeconfig is fetched from the control plane node of a managed
+cluster, and merged into ~/.kube/config, or the file specified with
+--output, as a context called kutti-CLUSTERNAME. If the driver uses NAT
+networking, the API server port (6443) of the control plane node must be
+published to a host port first.
+
+Examples:
+	kutti cluster kubeconfig dev
+	kutti cluster kubeconfig dev --output dev.kubeconfig
+	kutti cluster kubeconfig dev --remove
+`,
+				Args:              cobra.RangeArgs(0, 1),
+				ValidArgsFunction: NameValidArgs,
+				RunE:              clusterKubeconfigCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().StringP("output", "o", "", "kubeconfig file to update (default ~/.kube/config)")
+				c.MarkFlagFilename("output")
+
+				c.Flags().Bool("remove", false, "remove the cluster context instead of adding it")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "ssh-config [CLUSTERNAME]",
+				Short: "Generate OpenSSH client configuration for cluster nodes",
+				Long: `
+Generate OpenSSH client configuration for cluster nodes.
+
+A Host block called kutti-CLUSTERNAME-NODENAME is printed for each node,
+so that plain ssh, scp, VS Code Remote-SSH or Ansible can connect to it.
+The blocks use the SSH address of each node, the cluster's SSH username,
+and its identity file or cluster key. Passwords cannot be stored in an
+OpenSSH configuration, so the key must be installed on the nodes.
+
+With --install, the blocks are written to ~/.ssh/kutti_config instead, and
+an Include line for that file is added to ~/.ssh/config if it is not there.
+The key is also installed on every reachable node, using the cluster's SSH
+password. Running it again replaces the blocks of the cluster. With
+--remove, they are removed. They are also removed when the cluster is
+removed.
+
+Host key checking is disabled for nodes reached through the loopback
+address, as with drivers that use NAT networking, because forwarded ports
+are reused by nodes with different host keys. Other nodes are checked as
+usual.
+
+If the driver does not use NAT networking, the SSH address of a node is only
+known while it is running. Run this command again after starting nodes.
+
+Examples:
+	kutti cluster ssh-config dev
+	kutti cluster ssh-config dev --install
+	ssh kutti-dev-control
+	kutti cluster ssh-config dev --remove
+`,
+				Args:              cobra.RangeArgs(0, 1),
+				ValidArgsFunction: NameValidArgs,
+				RunE:              clusterSSHConfigCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().Bool("install", false, "write to ~/.ssh/kutti_config, and include it from ~/.ssh/config")
+				c.Flags().Bool("remove", false, "remove the cluster from ~/.ssh/kutti_config")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "inventory [CLUSTERNAME...]",
+				Short: "Generate an Ansible inventory of cluster nodes",
+				Long: `
+Generate an Ansible inventory of cluster nodes.
+
+Nodes of the specified clusters, or of all clusters if none are specified,
+are listed as hosts called kutti-CLUSTERNAME-NODENAME. ansible_host and
+ansible_port are set from the SSH address of each node, and ansible_user
+and ansible_ssh_private_key_file from the cluster's SSH credentials. Nodes
+whose SSH address is not available are left out. As with 'kutti cluster
+ssh-config', host key checking is disabled only for nodes reached through
+the loopback address, and the key must be installed on the nodes, for
+example with 'kutti cluster ssh-config --install'.
+
+Hosts are grouped by cluster, as kutti_CLUSTERNAME, and all cluster groups
+are children of a kutti group. Nodes of managed clusters are also grouped
+by role, as kutti_role_controlplane and kutti_role_worker.
+
+With --format ansible, the default, the inventory is printed in the JSON
+format of Ansible dynamic inventory scripts, and the --list and --host
+options that Ansible passes to such scripts are supported. With --format
+ansible-ini, it is printed as a static INI inventory.
+
+Examples:
+	kutti cluster inventory --format ansible
+	kutti cluster inventory dev --format ansible-ini > hosts.ini
+
+	# A dynamic inventory script for Ansible
+	#!/bin/sh
+	exec kutti cluster inventory --format ansible "$@"
+`,
+				ValidArgsFunction: NameValidArgs,
+				RunE:              clusterInventoryCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().StringP("format", "f", "ansible", "inventory format (ansible, ansible-ini)")
+				c.Flags().Bool("list", false, "list all hosts. This is the default, and is accepted for Ansible")
+				c.Flags().String("host", "", "print the variables of a single host, for Ansible")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "status [CLUSTERNAME]",
+				Short: "Show health of cluster nodes",
+				Long: `
+Show health of cluster nodes.
+
+For each node, the VM status is shown. For running nodes, SSH reachability
+and the state of the kubelet service are checked. For managed clusters, the
+Ready condition reported by Kubernetes is also shown. The HEALTH column
+summarizes the first problem found for each node.
+`,
+				Args:              cobra.RangeArgs(0, 1),
+				ValidArgsFunction: NameValidArgs,
+				RunE:              clusterStatusCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().StringP("output", "o", "table", "output format (table, json)")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "exec [CLUSTERNAME] -- COMMAND [ARGS...]",
+				Short: "Run a command on all cluster nodes",
+				Long: `
+Run a command on all cluster nodes.
+
+The command runs over SSH on every running node of the cluster, or on the
+nodes specified with --nodes, in parallel. Each line of output is prefixed
+with the name of the node it came from. A table of exit codes is shown at
+the end, and kutti exits with a nonzero code if the command failed on any
+node.
+
+Examples:
+	kutti cluster exec dev -- systemctl is-active containerd
+	kutti cluster exec dev --nodes worker1,worker2 -- 'sudo crictl rmi --prune'
+`,
+				ValidArgsFunction: NameValidArgs,
+				RunE:              clusterExecCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				c.Flags().StringSliceP("nodes", "n", []string{}, "comma-separated names of nodes to run the command on")
+				c.Flags().IntP("parallel", "P", defaultparallelism, "maximum number of nodes to run the command on at once")
+				c.Flags().Bool("fail-fast", false, "do not start the command on more nodes after a failure")
+				SetSSHFlags(c)
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "credentials",
+				Short: "Manage SSH credentials of a cluster",
+				Long: `
+Manage SSH credentials of a cluster.
+
+Commands that connect to cluster nodes over SSH resolve each credential
+from the command-line flag, then the cluster credentials set here, then
+the global settings ssh-username, ssh-password and ssh-identity-file, and
+finally the built-in defaults of kutti node images.
+
+Examples:
+	kutti cluster credentials set dev --username ops
+	kutti cluster credentials show dev
+	kutti cluster credentials rm dev --username
+`,
+			},
+			Subcommands: []*cli.Command{
+				{
+					Cmd: &cobra.Command{
+						Use:   "set [CLUSTERNAME]",
+						Short: "Set SSH credentials of a cluster",
+						Long: `
+Set SSH credentials of a cluster.
+
+Only the credentials specified by flags are changed. The password is
+stored in plain text in the kutti configuration file.
+`,
+						Args:              cobra.RangeArgs(0, 1),
+						ValidArgsFunction: NameValidArgs,
+						RunE:              clusterCredentialsSetCommand,
+						SilenceErrors:     true,
+					},
+					SetFlagsFunc: func(c *cobra.Command) {
+						c.Flags().StringP("username", "u", "", "username for SSH connections")
+						c.Flags().StringP("password", "p", "", "password for SSH connections")
+						c.Flags().StringP("identity-file", "i", "", "private key for SSH connections")
+						c.MarkFlagFilename("identity-file")
+					},
+				},
+				{
+					Cmd: &cobra.Command{
+						Use:                   "show [CLUSTERNAME]",
+						Aliases:               []string{"get", "ls", "list"},
+						Short:                 "Show SSH credentials of a cluster, and where they come from",
+						Args:                  cobra.RangeArgs(0, 1),
+						ValidArgsFunction:     NameValidArgs,
+						RunE:                  clusterCredentialsShowCommand,
+						SilenceErrors:         true,
+						DisableFlagsInUseLine: true,
+					},
+				},
+				{
+					Cmd: &cobra.Command{
+						Use:     "rm [CLUSTERNAME]",
+						Aliases: []string{"remove", "delete", "del", "clear"},
+						Short:   "Remove SSH credentials of a cluster",
+						Long: `
+Remove SSH credentials of a cluster.
+
+With no flags, all credentials of the cluster are removed, so that the
+global settings or built-in defaults apply again.
+`,
+						Args:              cobra.RangeArgs(0, 1),
+						ValidArgsFunction: NameValidArgs,
+						RunE:              clusterCredentialsRmCommand,
+						SilenceErrors:     true,
+					},
+					SetFlagsFunc: func(c *cobra.Command) {
+						c.Flags().Bool("username", false, "remove the username")
+						c.Flags().Bool("password", false, "remove the password")
+						c.Flags().Bool("identity-file", false, "remove the private key")
+					},
+				},
+			},
 		},
 	},
 }
@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
+
+	"github.com/spf13/cobra"
+)
+
+// SSH credential settings. Each can be set globally, using 'kutti setting
+// set', or per cluster, using 'kutti cluster credentials set'.
+const (
+	usernamesetting     = "ssh-username"
+	passwordsetting     = "ssh-password"
+	identityfilesetting = "ssh-identity-file"
+)
+
+// Sources of a credential value, in order of precedence.
+const (
+	credentialsourceflag    = "flag"
+	credentialsourcecluster = "cluster"
+	credentialsourceglobal  = "global"
+	credentialsourcedefault = "default"
+)
+
+// credential describes an SSH credential: the flag that overrides it, the
+// setting that stores it, and its built-in default.
+type credential struct {
+	flag    string
+	setting string
+	builtin string
+}
+
+var (
+	usernamecredential     = &credential{flag: "username", setting: usernamesetting, builtin: defaultSSHUsername}
+	passwordcredential     = &credential{flag: "password", setting: passwordsetting, builtin: defaultSSHPassword}
+	identityfilecredential = &credential{flag: "identity-file", setting: identityfilesetting, builtin: ""}
+)
+
+// credentials lists all SSH credentials, in display order.
+var credentials = []*credential{
+	usernamecredential,
+	passwordcredential,
+	identityfilecredential,
+}
+
+// resolve returns the value of a credential for a cluster, and where it
+// came from. The command may be nil, for operations that have no
+// credential flags.
+func (cr *credential) resolve(c *cobra.Command, clustername string) (string, string) {
+	if c != nil && c.Flags().Lookup(cr.flag) != nil && c.Flags().Changed(cr.flag) {
+		value, _ := c.Flags().GetString(cr.flag)
+		return value, credentialsourceflag
+	}
+
+	if value, ok := cli.ClusterSetting(clustername, cr.setting); ok {
+		return value, credentialsourcecluster
+	}
+
+	if value, ok := cli.Setting(cr.setting); ok {
+		return value, credentialsourceglobal
+	}
+
+	return cr.builtin, credentialsourcedefault
+}
+
+// newClusterClient returns an SSH client for the nodes of a cluster. The
+// username, password and private key are resolved from flags, cluster
+// settings, global settings and built-in defaults, in that order. If no
+// private key is specified, the cluster key is used. The password is
+// used if key authentication fails, and the public key is then installed
+// on the node.
+func newClusterClient(c *cobra.Command, clustername string) (*remote.Client, error) {
+	username, _ := usernamecredential.resolve(c, clustername)
+	password, _ := passwordcredential.resolve(c, clustername)
+	identityfile, err := resolveIdentityFile(c, clustername)
+	if err != nil {
+		return nil, err
+	}
+
+	return remote.NewWithKey(username, password, identityfile, true)
+}
+
+// resolveIdentityFile returns the private key for SSH connections to the
+// nodes of a cluster. If none is specified, the cluster key is used, and
+// generated if needed.
+func resolveIdentityFile(c *cobra.Command, clustername string) (string, error) {
+	identityfile, _ := identityfilecredential.resolve(c, clustername)
+	if identityfile != "" {
+		return identityfile, nil
+	}
+
+	return ensureClusterKey(clustername)
+}
@@ -1,10 +1,15 @@
 package cluster
 
 import (
+	"net"
+	"sort"
+	"strconv"
+
 	"github.com/kuttiproject/kuttilib"
 	"github.com/kuttiproject/kuttilog"
 
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
 
 	"github.com/spf13/cobra"
 )
@@ -21,6 +26,41 @@ func NameValidArgs(c *cobra.Command, args []string, toComplete string) ([]string
 	return cli.StringCompletions(possibilities, toComplete)
 }
 
+// ControlPlaneNode returns the control plane node of a managed cluster.
+// It returns false for unmanaged clusters.
+func ControlPlaneNode(cluster *kuttilib.Cluster) (*kuttilib.Node, bool) {
+	nodename, ok := cli.ClusterSetting(cluster.Name(), controlplanesetting)
+	if !ok {
+		return nil, false
+	}
+
+	return cluster.GetNode(nodename)
+}
+
+// SetSSHFlags adds the flags used by NewSSHClient to a command.
+func SetSSHFlags(c *cobra.Command) {
+	c.Flags().StringP("username", "u", "", "username for SSH connection, overriding credential settings")
+	c.Flags().StringP("password", "p", "", "password for SSH connection, used if key authentication fails")
+	c.Flags().StringP("identity-file", "i", "", "private key for SSH connection (default is the cluster key)")
+	c.MarkFlagFilename("identity-file")
+}
+
+// NewSSHClient returns an SSH client for the nodes of a cluster. Flags
+// added by SetSSHFlags override the credential settings of the cluster,
+// which override the global credential settings.
+func NewSSHClient(c *cobra.Command, cluster *kuttilib.Cluster) (*remote.Client, error) {
+	client, err := newClusterClient(c, cluster.Name())
+	if err != nil {
+		return nil, cli.WrapErrorMessagef(
+			1,
+			"could not load SSH key: %v",
+			err,
+		)
+	}
+
+	return client, nil
+}
+
 // StartNode starts a node.
 func StartNode(cluster *kuttilib.Cluster, nodename string, force bool) error {
 	node, ok := cluster.GetNode(nodename)
@@ -60,8 +100,8 @@ func StartNode(cluster *kuttilib.Cluster, nodename string, force bool) error {
 		err = node.Start()
 	}
 	if err != nil {
-		kuttilog.Printf(
-			kuttilog.Info,
+		return cli.WrapErrorMessagef(
+			1,
 			"Node '%v' could not be started: %v",
 			nodename,
 			err,
@@ -132,3 +172,82 @@ func StopNode(cluster *kuttilib.Cluster, nodename string, force bool) error {
 
 	return nil
 }
+
+// FindFreeHostPort returns a host port in the range set by the port-range
+// setting, that is free on the host and not used by any kutti cluster.
+func FindFreeHostPort(cluster *kuttilib.Cluster) (int, error) {
+	low, high, err := portRange()
+	if err != nil {
+		return 0, err
+	}
+
+	ports, err := findFreeHostPorts(cluster, low, high, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	return ports[0], nil
+}
+
+// HostPortClaim is a host port forwarded to a node port.
+type HostPortClaim struct {
+	HostPort int
+	Cluster  string
+	Node     string
+	NodePort int
+}
+
+// HostPortClaims returns the host ports forwarded to nodes of all kutti
+// clusters, including SSH ports, ordered by host port, cluster and node.
+// A host port may be claimed more than once.
+func HostPortClaims() []*HostPortClaim {
+	result := []*HostPortClaim{}
+	for clustername, cluster := range kuttilib.Clusters() {
+		nat := cluster.Driver().UsesNATNetworking()
+		for nodename, node := range cluster.Nodes() {
+			ports := node.Ports()
+			for nodeport, hostport := range ports {
+				result = append(result, &HostPortClaim{
+					HostPort: hostport,
+					Cluster:  clustername,
+					Node:     nodename,
+					NodePort: nodeport,
+				})
+			}
+
+			// The SSH forward may not be listed among the ports. For NAT
+			// drivers, the SSH address does not need a running node.
+			if _, ok := ports[nodesshport]; ok || !nat {
+				continue
+			}
+			_, portstring, err := net.SplitHostPort(node.SSHAddress())
+			if err != nil {
+				continue
+			}
+			if hostport, err := strconv.Atoi(portstring); err == nil {
+				result = append(result, &HostPortClaim{
+					HostPort: hostport,
+					Cluster:  clustername,
+					Node:     nodename,
+					NodePort: nodesshport,
+				})
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.HostPort != b.HostPort {
+			return a.HostPort < b.HostPort
+		}
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.NodePort < b.NodePort
+	})
+
+	return result
+}
@@ -2,7 +2,13 @@ package cluster
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/kuttiproject/kuttilog"
 
@@ -10,6 +16,7 @@ import (
 
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
 	"github.com/kuttiproject/kutti/internal/pkg/cmd/version"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
 
 	"github.com/spf13/cobra"
 )
@@ -133,6 +140,42 @@ func clusterRmCommand(c *cobra.Command, args []string) error {
 		kuttilog.Println(kuttilog.Info, "Default cluster reset.")
 	}
 
+	cli.RemoveClusterSettings(clustername)
+
+	err = removeClusterKey(clustername)
+	if err != nil {
+		kuttilog.Printf(
+			kuttilog.Info,
+			"Warning: could not remove SSH key: %v.",
+			err,
+		)
+	}
+
+	kubeconfigpath, err := defaultKubeconfigPath()
+	if err == nil {
+		removed, err := removeClusterKubeconfig(kubeconfigpath, clustername)
+		if err != nil {
+			kuttilog.Printf(
+				kuttilog.Info,
+				"Warning: could not remove cluster from kubeconfig: %v.",
+				err,
+			)
+		} else if removed {
+			kuttilog.Printf(kuttilog.Info, "Removed cluster from kubeconfig '%v'.", kubeconfigpath)
+		}
+	}
+
+	removed, err := removeSSHConfig(clustername)
+	if err != nil {
+		kuttilog.Printf(
+			kuttilog.Info,
+			"Warning: could not remove cluster from SSH configuration: %v.",
+			err,
+		)
+	} else if removed {
+		kuttilog.Println(kuttilog.Info, "Removed cluster from SSH configuration.")
+	}
+
 	return nil
 }
 
@@ -163,10 +206,20 @@ func clusterCreateCommand(c *cobra.Command, args []string) error {
 	}
 
 	unmanaged, _ := c.Flags().GetBool("unmanaged")
+
+	var nodenames []string
 	if !unmanaged {
+		nodenames, err = getmanagednodenames(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	sshport, _ := c.Flags().GetInt("sshport")
+	if !kuttilib.ValidPort(sshport) {
 		return cli.WrapErrorMessage(
 			1,
-			"managed cluster creation not yet implemented. Use --unmanaged",
+			"please provide a valid sshport. Valid ports are between 1 and 65535",
 		)
 	}
 
@@ -182,6 +235,23 @@ func clusterCreateCommand(c *cobra.Command, args []string) error {
 		)
 	}
 
+	// Nodes get the cluster key installed on first contact
+	_, err = ensureClusterKey(clustername)
+	if err != nil {
+		kuttilog.Printf(
+			kuttilog.Info,
+			"Warning: could not generate SSH key: %v. Password authentication will be used.",
+			err,
+		)
+	}
+
+	if !unmanaged {
+		err = createManagedNodes(clustername, nodenames, sshport)
+		if err != nil {
+			return err
+		}
+	}
+
 	if kuttilog.V(kuttilog.Info) {
 		kuttilog.Printf(kuttilog.Info, "Cluster '%v' created.\n", clustername)
 	} else {
@@ -197,6 +267,139 @@ func clusterCreateCommand(c *cobra.Command, args []string) error {
 	return nil
 }
 
+func getmanagednodenames(c *cobra.Command) ([]string, error) {
+	workers, _ := c.Flags().GetInt("workers")
+	if c.Flags().Changed("nodes") {
+		nodes, _ := c.Flags().GetInt("nodes")
+		if nodes < 1 {
+			return nil, cli.WrapErrorMessage(
+				1,
+				"a managed cluster needs at least one node",
+			)
+		}
+
+		workers = nodes - 1
+	}
+
+	if workers < 0 {
+		return nil, cli.WrapErrorMessage(
+			1,
+			"number of workers cannot be negative",
+		)
+	}
+
+	pattern, _ := c.Flags().GetString("name-pattern")
+	if strings.Count(pattern, "%") != 1 || strings.Count(pattern, "%d") != 1 {
+		return nil, cli.WrapErrorMessagef(
+			1,
+			"invalid name pattern '%v'. The pattern must contain %%d exactly once",
+			pattern,
+		)
+	}
+
+	nodenames := []string{controlplanenodename}
+	for i := 1; i <= workers; i++ {
+		nodenames = append(nodenames, fmt.Sprintf(pattern, i))
+	}
+
+	return nodenames, nil
+}
+
+func createManagedNodes(clustername string, nodenames []string, sshport int) error {
+	cluster, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	err := setupManagedNodes(cluster, nodenames, sshport)
+	if err != nil {
+		rollbackCluster(cluster)
+		return cli.WrapErrorMessagef(
+			1,
+			"could not create cluster '%v': %v",
+			clustername,
+			err,
+		)
+	}
+
+	return nil
+}
+
+func setupManagedNodes(cluster *kuttilib.Cluster, nodenames []string, sshport int) error {
+	// Check validity of node names before creating anything
+	for _, nodename := range nodenames {
+		err := cluster.ValidateNodeName(nodename)
+		if err != nil {
+			return fmt.Errorf("invalid node name '%v': %v", nodename, err)
+		}
+	}
+
+	// Find host ports for drivers that require SSH port forwarding
+	sshports := make([]int, len(nodenames))
+	if cluster.Driver().UsesNATNetworking() {
+		var err error
+		sshports, err = allocateHostPorts(cluster, sshport, len(nodenames))
+		if err != nil {
+			return err
+		}
+
+		for i, nodename := range nodenames {
+			kuttilog.Printf(
+				kuttilog.Verbose,
+				"Node '%v' will use host port %v for SSH.",
+				nodename,
+				sshports[i],
+			)
+		}
+	}
+
+	return bootstrapCluster(cluster, nodenames, sshports)
+}
+
+// allocateHostPorts finds the specified number of host ports that are
+// free on the host and not used by any kutti cluster, starting at the
+// specified port.
+func allocateHostPorts(cluster *kuttilib.Cluster, start int, count int) ([]int, error) {
+	return findFreeHostPorts(cluster, start, 65535, count)
+}
+
+// rollbackCluster deletes all nodes of a partially created cluster,
+// and then the cluster itself.
+func rollbackCluster(cluster *kuttilib.Cluster) {
+	clustername := cluster.Name()
+	kuttilog.Printf(kuttilog.Info, "Rolling back cluster '%v'...", clustername)
+
+	for _, nodename := range cluster.NodeNames() {
+		err := cluster.DeleteNode(nodename, true)
+		if err != nil {
+			kuttilog.Printf(
+				kuttilog.Quiet,
+				"Warning: could not delete node '%v': %v.",
+				nodename,
+				err,
+			)
+		}
+	}
+
+	err := kuttilib.DeleteCluster(clustername, true)
+	if err != nil {
+		kuttilog.Printf(
+			kuttilog.Quiet,
+			"Warning: could not delete cluster '%v': %v. Remove it using 'kutti cluster rm %v'.",
+			clustername,
+			err,
+			clustername,
+		)
+	}
+
+	cli.RemoveClusterSettings(clustername)
+	removeClusterKey(clustername)
+}
+
 func getclustername(args []string) (string, error) {
 	if len(args) == 0 {
 		clustername, ok := cli.Default("cluster")
@@ -213,6 +416,120 @@ func getclustername(args []string) (string, error) {
 	return args[0], nil
 }
 
+// noderesult is the outcome of an operation on one node.
+type noderesult struct {
+	Node   string
+	Result string
+	Error  string
+}
+
+// nodeGroups returns the node names of a cluster as two sorted groups:
+// control plane nodes and workers. All nodes of an unmanaged cluster are
+// treated as workers.
+func nodeGroups(cluster *kuttilib.Cluster) ([]string, []string) {
+	controlplanes := []string{}
+	workers := []string{}
+
+	controlplane, managed := ControlPlaneNode(cluster)
+	nodenames := cluster.NodeNames()
+	sort.Strings(nodenames)
+	for _, nodename := range nodenames {
+		if managed && nodename == controlplane.Name() {
+			controlplanes = append(controlplanes, nodename)
+		} else {
+			workers = append(workers, nodename)
+		}
+	}
+
+	return controlplanes, workers
+}
+
+// runNodeGroups runs an operation on groups of nodes. Groups are processed
+// in order. Nodes within a group are processed in parallel, with at most
+// the specified number of operations running at a time. The operation
+// returns a short description of the result.
+func runNodeGroups(groups [][]string, parallel int, operation func(nodename string) (string, error)) []*noderesult {
+	results := []*noderesult{}
+
+	for _, group := range groups {
+		groupresults := make([]*noderesult, len(group))
+		slots := make(chan struct{}, parallel)
+		var wg sync.WaitGroup
+
+		for i, nodename := range group {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+
+				result, err := operation(nodename)
+				groupresults[i] = &noderesult{Node: nodename, Result: result}
+				if err != nil {
+					groupresults[i].Error = err.Error()
+				}
+			}()
+		}
+
+		wg.Wait()
+		results = append(results, groupresults...)
+	}
+
+	return results
+}
+
+// reportNodeResults prints a summary of node results, and returns an
+// error if any node failed. The failure phrase completes the sentence
+// "N of M nodes ...".
+func reportNodeResults(results []*noderesult, failure string) error {
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	if kuttilog.V(kuttilog.Info) || failed > 0 {
+		var resultFormatter = cli.NewTableRenderer(
+			"noderesults",
+			[]*cli.TableColumn{
+				{Name: "Node", Width: 15},
+				{Name: "Result", Width: 15},
+				{Name: "Error", Width: 40},
+			},
+			"",
+		)
+		resultFormatter.Render(os.Stdout, results)
+	}
+
+	if failed > 0 {
+		return cli.WrapErrorMessagef(
+			1,
+			"%v of %v nodes %v",
+			failed,
+			len(results),
+			failure,
+		)
+	}
+
+	return nil
+}
+
+// The default number of nodes operated on at once.
+const defaultparallelism = 3
+
+func getparallel(c *cobra.Command) (int, error) {
+	parallel, _ := c.Flags().GetInt("parallel")
+	if parallel < 1 {
+		return 0, cli.WrapErrorMessage(
+			1,
+			"--parallel must be at least 1",
+		)
+	}
+
+	return parallel, nil
+}
+
 func clusterUpCommand(c *cobra.Command, args []string) error {
 	c.SilenceUsage = true
 
@@ -230,13 +547,34 @@ func clusterUpCommand(c *cobra.Command, args []string) error {
 		)
 	}
 
+	parallel, err := getparallel(c)
+	if err != nil {
+		return err
+	}
+
 	kuttilog.Printf(kuttilog.Info, "Bringing up cluster %v...\n", clustername)
 
-	for _, nodename := range cluster.NodeNames() {
-		StartNode(cluster, nodename, false)
-	}
+	// Control plane nodes start before workers
+	controlplanes, workers := nodeGroups(cluster)
+	results := runNodeGroups(
+		[][]string{controlplanes, workers},
+		parallel,
+		func(nodename string) (string, error) {
+			node, _ := cluster.GetNode(nodename)
+			if node.Status() == kuttilib.NodeStatusRunning {
+				return "Already running", nil
+			}
 
-	return nil
+			err := StartNode(cluster, nodename, false)
+			if err != nil {
+				return "Failed", err
+			}
+
+			return "Started", nil
+		},
+	)
+
+	return reportNodeResults(results, "could not be started")
 }
 
 func clusterDownCommand(c *cobra.Command, args []string) error {
@@ -256,10 +594,803 @@ func clusterDownCommand(c *cobra.Command, args []string) error {
 		)
 	}
 
+	parallel, err := getparallel(c)
+	if err != nil {
+		return err
+	}
+
 	kuttilog.Printf(kuttilog.Info, "Bringing down cluster %v...\n", clustername)
 
-	for _, nodename := range cluster.NodeNames() {
-		StopNode(cluster, nodename, false)
+	// Control plane nodes stop after workers
+	controlplanes, workers := nodeGroups(cluster)
+	results := runNodeGroups(
+		[][]string{workers, controlplanes},
+		parallel,
+		func(nodename string) (string, error) {
+			node, _ := cluster.GetNode(nodename)
+			if node.Status() == kuttilib.NodeStatusStopped {
+				return "Already stopped", nil
+			}
+
+			err := StopNode(cluster, nodename, false)
+			if err != nil {
+				return "Failed", err
+			}
+
+			return "Stopped", nil
+		},
+	)
+
+	return reportNodeResults(results, "could not be stopped")
+}
+
+func clusterApplyCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	filename, _ := c.Flags().GetString("file")
+	spec, err := loadClusterSpec(filename)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	actions, err := planClusterSpec(spec)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	if len(actions) == 0 {
+		kuttilog.Printf(kuttilog.Minimal, "Cluster '%v' matches the spec. Nothing to do.", spec.Name)
+		return nil
+	}
+
+	dryrun, _ := c.Flags().GetBool("dry-run")
+	if dryrun || kuttilog.V(kuttilog.Verbose) {
+		var planFormatter = cli.NewTableRenderer(
+			"clusterplan",
+			[]*cli.TableColumn{
+				{Name: "Action", Width: 10},
+				{Name: "Target", Width: 15},
+				{Name: "Details", Width: 40},
+			},
+			"",
+		)
+		planFormatter.Render(os.Stdout, actions)
+		kuttilog.Println(kuttilog.Quiet, summarizeSpecActions(actions))
+	}
+
+	if dryrun {
+		return nil
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Applying spec for cluster '%v'...", spec.Name)
+	err = applySpecActions(actions)
+	if err != nil {
+		return err
+	}
+
+	if kuttilog.V(kuttilog.Info) {
+		kuttilog.Printf(kuttilog.Info, "Cluster '%v' matches the spec.", spec.Name)
+	} else {
+		kuttilog.Println(kuttilog.Minimal, spec.Name)
+	}
+
+	return nil
+}
+
+func clusterExportSpecCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername := args[0]
+	cluster, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	format, _ := c.Flags().GetString("output")
+	result, err := renderClusterSpec(exportClusterSpec(cluster), format)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	os.Stdout.Write(result)
+	return nil
+}
+
+func clusterKubeconfigCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername, err := getclustername(args)
+	if err != nil {
+		return err
+	}
+
+	kubeconfigpath, _ := c.Flags().GetString("output")
+	if kubeconfigpath == "" {
+		kubeconfigpath, err = defaultKubeconfigPath()
+		if err != nil {
+			return cli.WrapError(1, err)
+		}
+	}
+
+	contextname, _, _ := kubeconfignames(clustername)
+
+	remove, _ := c.Flags().GetBool("remove")
+	if remove {
+		removed, err := removeClusterKubeconfig(kubeconfigpath, clustername)
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"could not update kubeconfig '%v': %v",
+				kubeconfigpath,
+				err,
+			)
+		}
+
+		if !removed {
+			return cli.WrapErrorMessagef(
+				2,
+				"context '%v' not found in kubeconfig '%v'",
+				contextname,
+				kubeconfigpath,
+			)
+		}
+
+		if kuttilog.V(kuttilog.Info) {
+			kuttilog.Printf(kuttilog.Info, "Context '%v' removed from '%v'.", contextname, kubeconfigpath)
+		} else {
+			kuttilog.Println(kuttilog.Minimal, contextname)
+		}
+
+		return nil
+	}
+
+	cluster, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	controlplane, ok := ControlPlaneNode(cluster)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			1,
+			"cluster '%v' is not a managed cluster",
+			clustername,
+		)
+	}
+
+	if controlplane.Status() != kuttilib.NodeStatusRunning {
+		return cli.WrapErrorMessagef(
+			1,
+			"control plane node '%v' is not running",
+			controlplane.Name(),
+		)
+	}
+
+	server, err := apiServerURL(controlplane)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	tempdir, err := os.MkdirTemp("", "kutti-kubeconfig")
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	kuttilog.Printf(kuttilog.Info, "Fetching kubeconfig from node %v...", controlplane.Name())
+	temppath := filepath.Join(tempdir, "config")
+	client, err := newClusterClient(nil, clustername)
+	if err != nil {
+		return cli.WrapErrorMessagef(1, "could not set up SSH key: %v", err)
+	}
+
+	err = client.CopyFrom(controlplane.SSHAddress(), nodekubeconfigpath, temppath, nil)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not fetch kubeconfig from node '%v': %v",
+			controlplane.Name(),
+			err,
+		)
+	}
+
+	data, err := os.ReadFile(temppath)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	admin, err := parseKubeconfig(data)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	err = renameAdminKubeconfig(admin, clustername, server)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	config, err := loadKubeconfig(kubeconfigpath)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not read kubeconfig '%v': %v",
+			kubeconfigpath,
+			err,
+		)
+	}
+
+	mergeKubeconfig(config, admin)
+
+	err = saveKubeconfig(kubeconfigpath, config)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not write kubeconfig '%v': %v",
+			kubeconfigpath,
+			err,
+		)
+	}
+
+	if kuttilog.V(kuttilog.Info) {
+		kuttilog.Printf(kuttilog.Info, "Context '%v' added to '%v'.", contextname, kubeconfigpath)
+	} else {
+		kuttilog.Println(kuttilog.Minimal, contextname)
+	}
+
+	return nil
+}
+
+func clusterSSHConfigCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername, err := getclustername(args)
+	if err != nil {
+		return err
+	}
+
+	remove, _ := c.Flags().GetBool("remove")
+	if remove {
+		removed, err := removeSSHConfig(clustername)
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"could not update SSH configuration: %v",
+				err,
+			)
+		}
+
+		if !removed {
+			return cli.WrapErrorMessagef(
+				2,
+				"cluster '%v' not found in SSH configuration",
+				clustername,
+			)
+		}
+
+		kuttilog.Printf(kuttilog.Info, "Cluster '%v' removed from SSH configuration.", clustername)
+		return nil
+	}
+
+	cluster, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	username, _ := usernamecredential.resolve(nil, clustername)
+	identityfile, err := resolveIdentityFile(nil, clustername)
+	if err != nil {
+		return cli.WrapErrorMessagef(1, "could not set up SSH key: %v", err)
+	}
+
+	hosts, skipped := clusterSSHHosts(cluster)
+	section := renderSSHConfig(clustername, hosts, skipped, username, identityfile)
+
+	install, _ := c.Flags().GetBool("install")
+	if !install {
+		fmt.Printf(
+			"# The identity file works only once it is installed on the nodes. Run\n"+
+				"# 'kutti cluster ssh-config %v --install' to install it.\n",
+			clustername,
+		)
+		os.Stdout.WriteString(section)
+		return nil
+	}
+
+	for _, nodename := range skipped {
+		kuttilog.Printf(
+			kuttilog.Info,
+			"Warning: could not fetch SSH address for node '%v'. Start it, and run this command again.",
+			nodename,
+		)
+	}
+
+	includepath, err := installSSHConfig(clustername, section)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not update SSH configuration: %v",
+			err,
+		)
+	}
+
+	// OpenSSH can only use the identity file once it is authorized on
+	// the nodes
+	client, err := newClusterClient(nil, clustername)
+	if err != nil {
+		return cli.WrapErrorMessagef(1, "could not set up SSH key: %v", err)
+	}
+
+	for _, host := range hosts {
+		err = client.InstallKey(net.JoinHostPort(host.hostname, host.port))
+		if err != nil {
+			kuttilog.Printf(
+				kuttilog.Minimal,
+				"Warning: could not install SSH key on node '%v': %v. Start it, and run this command again.",
+				host.nodename,
+				err,
+			)
+			continue
+		}
+
+		kuttilog.Printf(kuttilog.Verbose, "SSH key installed on node '%v'.", host.nodename)
+	}
+
+	if kuttilog.V(kuttilog.Info) {
+		kuttilog.Printf(kuttilog.Info, "SSH configuration for cluster '%v' written to '%v'.", clustername, includepath)
+		for _, host := range hosts {
+			kuttilog.Printf(kuttilog.Info, "    ssh %v", host.alias)
+		}
+	} else {
+		for _, host := range hosts {
+			kuttilog.Println(kuttilog.Minimal, host.alias)
+		}
+	}
+
+	return nil
+}
+
+func clusterInventoryCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	format, _ := c.Flags().GetString("format")
+	if format != "ansible" && format != "ansible-ini" {
+		return cli.WrapErrorMessagef(
+			1,
+			"unknown inventory format '%v'. Use ansible or ansible-ini",
+			format,
+		)
+	}
+
+	clusternames := args
+	if len(clusternames) == 0 {
+		clusternames = kuttilib.ClusterNames()
+		sort.Strings(clusternames)
+	}
+
+	hosts := []*inventoryhost{}
+	for _, clustername := range clusternames {
+		cluster, ok := kuttilib.GetCluster(clustername)
+		if !ok {
+			return cli.WrapErrorMessagef(
+				2,
+				"cluster '%v' not found",
+				clustername,
+			)
+		}
+
+		clusterhosts, err := clusterInventoryHosts(cluster)
+		if err != nil {
+			return cli.WrapErrorMessagef(1, "could not set up SSH key: %v", err)
+		}
+		hosts = append(hosts, clusterhosts...)
+	}
+
+	if format == "ansible-ini" {
+		os.Stdout.WriteString(iniInventory(hosts))
+		return nil
+	}
+
+	renderer := cli.NewJSONRenderer(2)
+
+	// Ansible calls dynamic inventory scripts with --host for each host
+	// only if _meta is missing, but scripts must still support it.
+	hostname, _ := c.Flags().GetString("host")
+	if hostname != "" {
+		hostvars := map[string]interface{}{}
+		for _, h := range hosts {
+			if h.name == hostname {
+				hostvars = h.hostvars()
+				break
+			}
+		}
+		renderer.Render(os.Stdout, hostvars)
+		return nil
+	}
+
+	renderer.Render(os.Stdout, ansibleInventory(hosts))
+	return nil
+}
+
+func clusterStatusCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername, err := getclustername(args)
+	if err != nil {
+		return err
+	}
+
+	cluster, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	output, _ := c.Flags().GetString("output")
+	if output != "table" && output != "json" {
+		return cli.WrapErrorMessagef(
+			1,
+			"unknown output format '%v'. Use table or json",
+			output,
+		)
+	}
+
+	kuttilog.Printf(kuttilog.Verbose, "Checking nodes of cluster %v...", clustername)
+
+	client, err := newClusterClient(nil, clustername)
+	if err != nil {
+		return cli.WrapErrorMessagef(1, "could not set up SSH key: %v", err)
+	}
+
+	nodenames := cluster.NodeNames()
+	sort.Strings(nodenames)
+
+	results := make([]*nodehealth, len(nodenames))
+	var wg sync.WaitGroup
+	for i, nodename := range nodenames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node, _ := cluster.GetNode(nodename)
+			results[i] = checkNodeHealth(client, node)
+		}()
+	}
+	wg.Wait()
+
+	controlplane, managed := ControlPlaneNode(cluster)
+	if managed {
+		var controlplanehealth *nodehealth
+		for _, result := range results {
+			if result.Name == controlplane.Name() {
+				controlplanehealth = result
+			}
+		}
+
+		var statuses map[string]string
+		if controlplanehealth != nil && controlplanehealth.SSH == healthOK {
+			statuses, err = kubernetesNodeStatus(client, controlplane)
+		}
+
+		for _, result := range results {
+			if statuses != nil {
+				result.applyKubernetesStatus(statuses)
+			} else if result.Health == healthHealthy {
+				result.Kubernetes = healthUnknown
+			}
+		}
+
+		if err != nil && controlplanehealth.Health == healthHealthy {
+			controlplanehealth.Health = healthAPIUnreachable
+		}
+	}
+
+	if output == "json" {
+		renderer := cli.NewJSONRenderer(2)
+		renderer.Render(os.Stdout, results)
+		return nil
+	}
+
+	var statusFormatter = cli.NewTableRenderer(
+		"clusterstatus",
+		[]*cli.TableColumn{
+			{Name: "Name", Width: 15},
+			{Name: "Role", Width: 13},
+			{Name: "VMStatus", Title: "VM", Width: 10},
+			{Name: "SSH", Width: 11},
+			{Name: "Kubelet", Width: 10},
+			{Name: "Kubernetes", Width: 14},
+			{Name: "Health", Width: 16},
+		},
+		"",
+	)
+	statusFormatter.Render(os.Stdout, results)
+
+	return nil
+}
+
+func clusterExecCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	// The cluster name, if any, comes before the --
+	dashat := c.ArgsLenAtDash()
+	if dashat == -1 || dashat > 1 {
+		return cli.WrapErrorMessage(
+			1,
+			"use -- to separate the command from the cluster name",
+		)
+	}
+	if dashat == len(args) {
+		return cli.WrapErrorMessage(
+			1,
+			"no command specified",
+		)
+	}
+
+	clustername, err := getclustername(args[:dashat])
+	if err != nil {
+		return err
+	}
+
+	cluster, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	parallel, err := getparallel(c)
+	if err != nil {
+		return err
+	}
+
+	failfast, _ := c.Flags().GetBool("fail-fast")
+	client, err := NewSSHClient(c, cluster)
+	if err != nil {
+		return err
+	}
+
+	nodes := cluster.Nodes()
+	selected, _ := c.Flags().GetStringSlice("nodes")
+	nodenames := selected
+	if len(selected) == 0 {
+		for nodename := range nodes {
+			nodenames = append(nodenames, nodename)
+		}
+		sort.Strings(nodenames)
+	}
+
+	width := 0
+	for _, nodename := range nodenames {
+		if _, ok := nodes[nodename]; !ok {
+			return cli.WrapErrorMessagef(
+				2,
+				"node '%v' not found",
+				nodename,
+			)
+		}
+
+		if len(nodename) > width {
+			width = len(nodename)
+		}
+	}
+
+	command := strings.Join(args[dashat:], " ")
+	kuttilog.Printf(kuttilog.Verbose, "Running on cluster %v: %v", clustername, command)
+
+	var outputmutex sync.Mutex
+	var failed atomic.Bool
+
+	results := runNodeGroups(
+		[][]string{nodenames},
+		parallel,
+		func(nodename string) (string, error) {
+			if failfast && failed.Load() {
+				return "Skipped", nil
+			}
+
+			// Nodes that are not running are only a failure if they
+			// were explicitly selected
+			node := nodes[nodename]
+			if node.Status() != kuttilib.NodeStatusRunning {
+				if len(selected) == 0 {
+					return "Not running", nil
+				}
+
+				failed.Store(true)
+				return "Not running", fmt.Errorf("node '%v' is not running", nodename)
+			}
+
+			prefix := fmt.Sprintf("%-*s | ", width, nodename)
+			stdout := cli.NewPrefixWriter(os.Stdout, prefix, &outputmutex)
+			stderr := cli.NewPrefixWriter(os.Stderr, prefix, &outputmutex)
+			exitstatus, err := client.Run(node.SSHAddress(), command, nil, stdout, stderr)
+			stdout.Flush()
+			stderr.Flush()
+
+			if err != nil {
+				failed.Store(true)
+				return "Failed", err
+			}
+
+			result := fmt.Sprintf("Exit code %v", exitstatus)
+			if exitstatus != 0 {
+				failed.Store(true)
+				return result, fmt.Errorf("command exited with status %v", exitstatus)
+			}
+
+			return result, nil
+		},
+	)
+
+	return reportNodeResults(results, "failed to run the command")
+}
+
+func getexistingclustername(args []string) (string, error) {
+	clustername, err := getclustername(args)
+	if err != nil {
+		return "", err
+	}
+
+	_, ok := kuttilib.GetCluster(clustername)
+	if !ok {
+		return "", cli.WrapErrorMessagef(
+			2,
+			"cluster '%v' not found",
+			clustername,
+		)
+	}
+
+	return clustername, nil
+}
+
+func clusterCredentialsSetCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername, err := getexistingclustername(args)
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+	for _, cr := range credentials {
+		if !c.Flags().Changed(cr.flag) {
+			continue
+		}
+
+		value, _ := c.Flags().GetString(cr.flag)
+		if cr.setting == identityfilesetting && value != "" {
+			value, err = filepath.Abs(value)
+			if err != nil {
+				return cli.WrapError(1, err)
+			}
+
+			_, err = remote.LoadKey(value)
+			if err != nil {
+				return cli.WrapErrorMessagef(
+					1,
+					"could not load private key '%v': %v",
+					value,
+					err,
+				)
+			}
+		}
+
+		err = cli.SetClusterSetting(clustername, cr.setting, value)
+		if err != nil {
+			return cli.WrapError(1, err)
+		}
+
+		kuttilog.Printf(kuttilog.Verbose, "Credential %v set for cluster %v.", cr.flag, clustername)
+		changed++
+	}
+
+	if changed == 0 {
+		return cli.WrapErrorMessage(
+			1,
+			"specify at least one of --username, --password or --identity-file",
+		)
+	}
+
+	if kuttilog.V(kuttilog.Info) {
+		kuttilog.Printf(kuttilog.Info, "Credentials set for cluster '%v'.", clustername)
+	} else {
+		kuttilog.Println(kuttilog.Minimal, clustername)
+	}
+
+	return nil
+}
+
+type credentialview struct {
+	Credential string
+	Value      string
+	Source     string
+}
+
+func clusterCredentialsShowCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername, err := getexistingclustername(args)
+	if err != nil {
+		return err
+	}
+
+	views := make([]*credentialview, 0, len(credentials))
+	for _, cr := range credentials {
+		value, source := cr.resolve(nil, clustername)
+		value = cli.MaskSetting(cr.setting, value)
+		if cr.setting == identityfilesetting && value == "" {
+			value = "(cluster key)"
+		}
+
+		views = append(views, &credentialview{
+			Credential: cr.flag,
+			Value:      value,
+			Source:     source,
+		})
+	}
+
+	var credentialsFormatter = cli.NewTableRenderer(
+		"clustercredentials",
+		[]*cli.TableColumn{
+			{Name: "Credential", Width: 15},
+			{Name: "Value", Width: 40},
+			{Name: "Source", Width: 10},
+		},
+		"",
+	)
+	credentialsFormatter.Render(os.Stdout, views)
+
+	return nil
+}
+
+func clusterCredentialsRmCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	clustername, err := getexistingclustername(args)
+	if err != nil {
+		return err
+	}
+
+	// With no flags, all credentials are removed
+	all := true
+	for _, cr := range credentials {
+		if c.Flags().Changed(cr.flag) {
+			all = false
+		}
+	}
+
+	for _, cr := range credentials {
+		remove, _ := c.Flags().GetBool(cr.flag)
+		if !all && !remove {
+			continue
+		}
+
+		err = cli.RemoveClusterSetting(clustername, cr.setting)
+		if err != nil {
+			return cli.WrapError(1, err)
+		}
+	}
+
+	if kuttilog.V(kuttilog.Info) {
+		kuttilog.Printf(kuttilog.Info, "Credentials removed for cluster '%v'.", clustername)
+	} else {
+		kuttilog.Println(kuttilog.Minimal, clustername)
 	}
 
 	return nil
@@ -0,0 +1,136 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/kuttiproject/kuttilib"
+
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
+)
+
+// Values used in health reports.
+const (
+	healthOK          = "OK"
+	healthUnreachable = "Unreachable"
+	healthNotChecked  = "-"
+
+	healthHealthy         = "Healthy"
+	healthStopped         = "Stopped"
+	healthVMError         = "VM error"
+	healthSSHUnreachable  = "SSH unreachable"
+	healthKubeletInactive = "Kubelet inactive"
+	healthNotReady        = "NotReady"
+	healthNotRegistered   = "Not registered"
+	healthAPIUnreachable  = "API unreachable"
+	healthUnknown         = "Unknown"
+)
+
+// nodehealth is the health of a node, as seen by the hypervisor, over SSH
+// and by Kubernetes.
+type nodehealth struct {
+	Name       string
+	Role       string
+	VMStatus   string
+	SSH        string
+	Kubelet    string
+	Kubernetes string
+	Health     string
+}
+
+func noderole(cluster *kuttilib.Cluster, nodename string) string {
+	controlplane, managed := ControlPlaneNode(cluster)
+	if !managed {
+		return ""
+	}
+
+	if controlplane.Name() == nodename {
+		return "control-plane"
+	}
+
+	return "worker"
+}
+
+// kubernetesNodeStatus returns the STATUS column of 'kubectl get nodes',
+// run on the control plane node, for each node.
+func kubernetesNodeStatus(client *remote.Client, controlplane *kuttilib.Node) (map[string]string, error) {
+	output, err := runOnNode(client, controlplane, "kubectl get nodes --no-headers")
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for _, line := range output {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		result[fields[0]] = fields[1]
+	}
+
+	return result, nil
+}
+
+// checkNodeHealth checks a node from the hypervisor and over SSH. The
+// Kubernetes status is filled in separately.
+func checkNodeHealth(client *remote.Client, node *kuttilib.Node) *nodehealth {
+	result := &nodehealth{
+		Name:       node.Name(),
+		Role:       noderole(node.Cluster(), node.Name()),
+		VMStatus:   string(node.Status()),
+		SSH:        healthNotChecked,
+		Kubelet:    healthNotChecked,
+		Kubernetes: healthNotChecked,
+	}
+
+	switch node.Status() {
+	case kuttilib.NodeStatusRunning:
+	case kuttilib.NodeStatusStopped:
+		result.Health = healthStopped
+		return result
+	default:
+		result.Health = healthVMError
+		return result
+	}
+
+	_, err := runOnNode(client, node, "true")
+	if err != nil {
+		result.SSH = healthUnreachable
+		result.Health = healthSSHUnreachable
+		return result
+	}
+	result.SSH = healthOK
+
+	// is-active exits with a nonzero code for inactive units, so the
+	// output is what matters.
+	output, err := runOnNode(client, node, "systemctl is-active kubelet || true")
+	if err == nil && len(output) > 0 {
+		result.Kubelet = strings.TrimSpace(output[0])
+	}
+	if result.Kubelet != "active" {
+		result.Health = healthKubeletInactive
+		return result
+	}
+
+	result.Health = healthHealthy
+	return result
+}
+
+// applyKubernetesStatus fills in the Kubernetes Ready condition of a node,
+// and downgrades its health if it is not Ready.
+func (h *nodehealth) applyKubernetesStatus(statuses map[string]string) {
+	if h.Health != healthHealthy {
+		return
+	}
+
+	status, ok := statuses[h.Name]
+	if !ok {
+		h.Kubernetes = healthNotRegistered
+		h.Health = healthNotRegistered
+		return
+	}
+
+	h.Kubernetes = status
+	if !strings.HasPrefix(status, "Ready") {
+		h.Health = healthNotReady
+	}
+}
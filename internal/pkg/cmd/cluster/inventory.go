@@ -0,0 +1,233 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/kuttilib"
+	"github.com/kuttiproject/kuttilog"
+)
+
+// Node roles in managed clusters. Nodes of unmanaged clusters have none.
+const (
+	rolecontrolplane = "controlplane"
+	roleworker       = "worker"
+)
+
+// inventoryhost is a node, as seen by Ansible.
+type inventoryhost struct {
+	name         string
+	cluster      string
+	node         string
+	role         string
+	host         string
+	port         int
+	user         string
+	identityfile string
+}
+
+// Ansible group names should be valid Python identifiers, so cluster and
+// role names are prefixed. Cluster names cannot contain underscores, so
+// the two kinds of group cannot clash.
+func inventoryClusterGroupName(clustername string) string {
+	return "kutti_" + clustername
+}
+
+func inventoryRoleGroupName(role string) string {
+	return "kutti_role_" + role
+}
+
+// clusterInventoryHosts returns the nodes of a cluster which have an SSH
+// address, in name order.
+func clusterInventoryHosts(cluster *kuttilib.Cluster) ([]*inventoryhost, error) {
+	clustername := cluster.Name()
+	username, _ := usernamecredential.resolve(nil, clustername)
+	identityfile, err := resolveIdentityFile(nil, clustername)
+	if err != nil {
+		return nil, err
+	}
+
+	controlplane, managed := ControlPlaneNode(cluster)
+
+	nodenames := cluster.NodeNames()
+	sort.Strings(nodenames)
+
+	result := make([]*inventoryhost, 0, len(nodenames))
+	for _, nodename := range nodenames {
+		node, ok := cluster.GetNode(nodename)
+		if !ok {
+			continue
+		}
+
+		host, portstring, err := net.SplitHostPort(node.SSHAddress())
+		if err != nil {
+			kuttilog.Printf(
+				kuttilog.Verbose,
+				"Skipping node '%v' of cluster '%v': SSH address not available.",
+				nodename,
+				clustername,
+			)
+			continue
+		}
+		port, _ := strconv.Atoi(portstring)
+
+		role := ""
+		if managed {
+			role = roleworker
+			if nodename == controlplane.Name() {
+				role = rolecontrolplane
+			}
+		}
+
+		result = append(result, &inventoryhost{
+			name:         sshHostAlias(clustername, nodename),
+			cluster:      clustername,
+			node:         nodename,
+			role:         role,
+			host:         host,
+			port:         port,
+			user:         username,
+			identityfile: identityfile,
+		})
+	}
+
+	return result, nil
+}
+
+// hostvars returns the Ansible variables of a host.
+func (h *inventoryhost) hostvars() map[string]interface{} {
+	result := map[string]interface{}{
+		"ansible_host":                 h.host,
+		"ansible_port":                 h.port,
+		"ansible_user":                 h.user,
+		"ansible_ssh_private_key_file": h.identityfile,
+		"kutti_cluster":                h.cluster,
+		"kutti_node":                   h.node,
+	}
+	if skipHostKeyCheck(h.host) {
+		result["ansible_ssh_common_args"] = "-o StrictHostKeyChecking=no -o UserKnownHostsFile=" + nullKnownHostsFile()
+	}
+	if h.role != "" {
+		result["kutti_role"] = h.role
+	}
+
+	return result
+}
+
+// inventoryGroups returns the Ansible groups of a set of hosts: one for
+// each cluster, one for each role, and a kutti group containing the
+// cluster groups. Host names in each group are sorted.
+func inventoryGroups(hosts []*inventoryhost) (map[string][]string, []string) {
+	groups := map[string][]string{}
+	clustergroups := []string{}
+	for _, h := range hosts {
+		clustergroup := inventoryClusterGroupName(h.cluster)
+		if _, ok := groups[clustergroup]; !ok {
+			clustergroups = append(clustergroups, clustergroup)
+		}
+		groups[clustergroup] = append(groups[clustergroup], h.name)
+
+		if h.role != "" {
+			rolegroup := inventoryRoleGroupName(h.role)
+			groups[rolegroup] = append(groups[rolegroup], h.name)
+		}
+	}
+
+	for _, members := range groups {
+		sort.Strings(members)
+	}
+	sort.Strings(clustergroups)
+
+	return groups, clustergroups
+}
+
+// ansibleInventory returns a set of hosts in the JSON format expected from
+// Ansible dynamic inventory scripts, including host variables in _meta.
+func ansibleInventory(hosts []*inventoryhost) map[string]interface{} {
+	groups, clustergroups := inventoryGroups(hosts)
+
+	result := map[string]interface{}{}
+	for name, members := range groups {
+		result[name] = map[string]interface{}{
+			"hosts": members,
+		}
+	}
+	result["kutti"] = map[string]interface{}{
+		"children": clustergroups,
+	}
+
+	hostvars := map[string]interface{}{}
+	for _, h := range hosts {
+		hostvars[h.name] = h.hostvars()
+	}
+	result["_meta"] = map[string]interface{}{
+		"hostvars": hostvars,
+	}
+
+	return result
+}
+
+// iniInventory returns a set of hosts as a static Ansible inventory in
+// INI format.
+func iniInventory(hosts []*inventoryhost) string {
+	groups, clustergroups := inventoryGroups(hosts)
+
+	var b strings.Builder
+	for _, clustergroup := range clustergroups {
+		fmt.Fprintf(&b, "[%v]\n", clustergroup)
+		for _, h := range hosts {
+			if inventoryClusterGroupName(h.cluster) != clustergroup {
+				continue
+			}
+
+			vars := h.hostvars()
+			names := make([]string, 0, len(vars))
+			for name := range vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fmt.Fprint(&b, h.name)
+			for _, name := range names {
+				fmt.Fprintf(&b, " %v=%v", name, iniValue(vars[name]))
+			}
+			fmt.Fprintln(&b)
+		}
+		fmt.Fprintln(&b)
+	}
+
+	for _, role := range []string{rolecontrolplane, roleworker} {
+		rolegroup := inventoryRoleGroupName(role)
+		members, ok := groups[rolegroup]
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&b, "[%v]\n", rolegroup)
+		for _, member := range members {
+			fmt.Fprintln(&b, member)
+		}
+		fmt.Fprintln(&b)
+	}
+
+	fmt.Fprintln(&b, "[kutti:children]")
+	for _, clustergroup := range clustergroups {
+		fmt.Fprintln(&b, clustergroup)
+	}
+
+	return b.String()
+}
+
+// iniValue quotes a host variable value for an INI inventory if it
+// contains spaces.
+func iniValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if strings.ContainsAny(s, " \t'") {
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+
+	return s
+}
@@ -0,0 +1,245 @@
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kuttiproject/kuttilib"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// The API server port on the control plane node.
+	apiservernodeport = 6443
+	// The path, relative to the SSH user's home, where bootstrap places
+	// a copy of admin.conf.
+	nodekubeconfigpath = ".kube/config"
+)
+
+// kubeconfig holds a kubeconfig file as generic YAML, so that fields
+// kutti does not know about survive a merge.
+type kubeconfig map[string]interface{}
+
+func kubeconfignames(clustername string) (contextname string, kubeclustername string, username string) {
+	contextname = "kutti-" + clustername
+	return contextname, contextname, contextname + "-admin"
+}
+
+// defaultKubeconfigPath returns the path of the kubeconfig file used by
+// kubectl by default.
+func defaultKubeconfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".kube", "config"), nil
+}
+
+func parseKubeconfig(data []byte) (kubeconfig, error) {
+	// Unmarshal into a plain map, because yaml.v3 would use the named
+	// map type for nested mappings too.
+	result := map[string]interface{}{}
+	err := yaml.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse kubeconfig: %v", err)
+	}
+
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+
+	return kubeconfig(result), nil
+}
+
+// loadKubeconfig reads a kubeconfig file. If the file does not exist, an
+// empty kubeconfig is returned.
+func loadKubeconfig(path string) (kubeconfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return kubeconfig{
+			"apiVersion": "v1",
+			"kind":       "Config",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKubeconfig(data)
+}
+
+func saveKubeconfig(path string, config kubeconfig) error {
+	data, err := yaml.Marshal(map[string]interface{}(config))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// namedlist returns the named entries of a kubeconfig section, such as
+// clusters, contexts or users.
+func (k kubeconfig) namedlist(section string) []interface{} {
+	list, _ := k[section].([]interface{})
+	return list
+}
+
+func (k kubeconfig) first(section string) (map[string]interface{}, bool) {
+	list := k.namedlist(section)
+	if len(list) == 0 {
+		return nil, false
+	}
+
+	entry, ok := list[0].(map[string]interface{})
+	return entry, ok
+}
+
+// setnamed adds a named entry to a section, replacing any entry with the
+// same name.
+func (k kubeconfig) setnamed(section string, entry map[string]interface{}) {
+	k.removenamed(section, entry["name"])
+	k[section] = append(k.namedlist(section), entry)
+}
+
+// removenamed removes a named entry from a section, and reports whether
+// it was found.
+func (k kubeconfig) removenamed(section string, name interface{}) bool {
+	list := k.namedlist(section)
+	result := make([]interface{}, 0, len(list))
+	found := false
+	for _, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if ok && entry["name"] == name {
+			found = true
+			continue
+		}
+		result = append(result, item)
+	}
+
+	k[section] = result
+	return found
+}
+
+// renameAdminKubeconfig changes the cluster, user and context names in a
+// kubeadm admin.conf to kutti names, and points it at the specified API
+// server URL.
+func renameAdminKubeconfig(admin kubeconfig, clustername string, server string) error {
+	contextname, kubeclustername, username := kubeconfignames(clustername)
+
+	clusterentry, ok := admin.first("clusters")
+	if !ok {
+		return fmt.Errorf("kubeconfig has no clusters")
+	}
+	userentry, ok := admin.first("users")
+	if !ok {
+		return fmt.Errorf("kubeconfig has no users")
+	}
+
+	clusterdata, ok := clusterentry["cluster"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("kubeconfig cluster entry is invalid")
+	}
+	clusterdata["server"] = server
+
+	clusterentry["name"] = kubeclustername
+	userentry["name"] = username
+	admin["clusters"] = []interface{}{clusterentry}
+	admin["users"] = []interface{}{userentry}
+	admin["contexts"] = []interface{}{
+		map[string]interface{}{
+			"name": contextname,
+			"context": map[string]interface{}{
+				"cluster": kubeclustername,
+				"user":    username,
+			},
+		},
+	}
+	admin["current-context"] = contextname
+
+	return nil
+}
+
+// mergeKubeconfig adds the entries of a renamed admin kubeconfig to
+// another kubeconfig. If the other kubeconfig has no current context,
+// it is set to the new one.
+func mergeKubeconfig(dest kubeconfig, admin kubeconfig) {
+	for _, section := range []string{"clusters", "contexts", "users"} {
+		for _, item := range admin.namedlist(section) {
+			if entry, ok := item.(map[string]interface{}); ok {
+				dest.setnamed(section, entry)
+			}
+		}
+	}
+
+	if current, _ := dest["current-context"].(string); current == "" {
+		dest["current-context"] = admin["current-context"]
+	}
+}
+
+// removeKubeconfigEntries removes the entries for a kutti cluster from a
+// kubeconfig, and reports whether there were any.
+func removeKubeconfigEntries(config kubeconfig, clustername string) bool {
+	contextname, kubeclustername, username := kubeconfignames(clustername)
+
+	removedcontext := config.removenamed("contexts", contextname)
+	removedcluster := config.removenamed("clusters", kubeclustername)
+	removeduser := config.removenamed("users", username)
+
+	if current, _ := config["current-context"].(string); current == contextname {
+		config["current-context"] = ""
+	}
+
+	return removedcontext || removedcluster || removeduser
+}
+
+// removeClusterKubeconfig removes the entries for a kutti cluster from a
+// kubeconfig file, and reports whether there were any. It is not an error
+// if the file does not exist.
+func removeClusterKubeconfig(path string, clustername string) (bool, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	config, err := loadKubeconfig(path)
+	if err != nil {
+		return false, err
+	}
+
+	if !removeKubeconfigEntries(config, clustername) {
+		return false, nil
+	}
+
+	return true, saveKubeconfig(path, config)
+}
+
+// apiServerURL returns the URL at which the host can reach the API server
+// running on the control plane node.
+func apiServerURL(controlplane *kuttilib.Node) (string, error) {
+	if !controlplane.Cluster().Driver().UsesNATNetworking() {
+		address := controlplane.IPAddress()
+		if address == "" {
+			return "", fmt.Errorf("could not fetch IP address for node '%v'", controlplane.Name())
+		}
+
+		return fmt.Sprintf("https://%v:%v", address, apiservernodeport), nil
+	}
+
+	hostport, ok := controlplane.Ports()[apiservernodeport]
+	if !ok {
+		return "", fmt.Errorf(
+			"API server port is not published. Use 'kutti node publish %v --cluster %v --nodeport %v --hostport PORT' first",
+			controlplane.Name(),
+			controlplane.Cluster().Name(),
+			apiservernodeport,
+		)
+	}
+
+	return fmt.Sprintf("https://127.0.0.1:%v", hostport), nil
+}
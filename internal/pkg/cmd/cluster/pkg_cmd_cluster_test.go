@@ -0,0 +1,376 @@
+package cluster
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseClusterSpec(t *testing.T) {
+
+	testCases := []struct {
+		name          string
+		spec          string
+		errorexpected bool
+		nodecount     int
+	}{
+		{
+			name: "managed yaml",
+			spec: `
+name: dev
+driver: vbox
+k8sversion: "1.30"
+managed: true
+nodes:
+  - name: control
+    controlplane: true
+    sshport: 10022
+    ports:
+      - nodeport: 6443
+        hostport: 16443
+  - name: worker1
+`,
+			errorexpected: false,
+			nodecount:     2,
+		},
+		{
+			name:          "unmanaged json",
+			spec:          `{"name": "lab", "driver": "vbox", "k8sversion": "1.30", "nodes": [{"name": "node1", "sshport": 10022}]}`,
+			errorexpected: false,
+			nodecount:     1,
+		},
+		{
+			name:          "missing driver",
+			spec:          `{"name": "lab", "k8sversion": "1.30"}`,
+			errorexpected: true,
+		},
+		{
+			name:          "managed without control plane",
+			spec:          `{"name": "dev", "driver": "vbox", "k8sversion": "1.30", "managed": true, "nodes": [{"name": "worker1"}]}`,
+			errorexpected: true,
+		},
+		{
+			name:          "unmanaged with control plane",
+			spec:          `{"name": "dev", "driver": "vbox", "k8sversion": "1.30", "nodes": [{"name": "control", "controlplane": true}]}`,
+			errorexpected: true,
+		},
+		{
+			name:          "duplicate node",
+			spec:          `{"name": "lab", "driver": "vbox", "k8sversion": "1.30", "nodes": [{"name": "node1"}, {"name": "node1"}]}`,
+			errorexpected: true,
+		},
+		{
+			name:          "duplicate host port",
+			spec:          `{"name": "lab", "driver": "vbox", "k8sversion": "1.30", "nodes": [{"name": "node1", "sshport": 10022}, {"name": "node2", "ports": [{"nodeport": 80, "hostport": 10022}]}]}`,
+			errorexpected: true,
+		},
+		{
+			name:          "ssh port published",
+			spec:          `{"name": "lab", "driver": "vbox", "k8sversion": "1.30", "nodes": [{"name": "node1", "ports": [{"nodeport": 22, "hostport": 10022}]}]}`,
+			errorexpected: true,
+		},
+		{
+			name:          "invalid port",
+			spec:          `{"name": "lab", "driver": "vbox", "k8sversion": "1.30", "nodes": [{"name": "node1", "ports": [{"nodeport": 80, "hostport": 70000}]}]}`,
+			errorexpected: true,
+		},
+		{
+			name:          "not a spec",
+			spec:          `[1, 2, 3]`,
+			errorexpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		result, err := parseClusterSpec([]byte(tc.spec))
+		if err == nil {
+			if tc.errorexpected {
+				t.Fatalf("case '%v' expected an error. Didn't happen", tc.name)
+			}
+		} else {
+			if !tc.errorexpected {
+				t.Fatalf("case '%v' failed with unexpected error: %v", tc.name, err)
+			}
+
+			continue
+		}
+
+		if len(result.Nodes) != tc.nodecount {
+			t.Fatalf("case '%v': expected %v nodes, got %v", tc.name, tc.nodecount, len(result.Nodes))
+		}
+	}
+
+}
+
+func TestKubeconfigMerge(t *testing.T) {
+	admin, err := parseKubeconfig([]byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: Q0E=
+    server: https://10.0.2.15:6443
+  name: kubernetes
+contexts:
+- context:
+    cluster: kubernetes
+    user: kubernetes-admin
+  name: kubernetes-admin@kubernetes
+current-context: kubernetes-admin@kubernetes
+users:
+- name: kubernetes-admin
+  user:
+    client-certificate-data: Q0VSVA==
+`))
+	if err != nil {
+		t.Fatalf("could not parse admin kubeconfig: %v", err)
+	}
+
+	err = renameAdminKubeconfig(admin, "dev", "https://127.0.0.1:16443")
+	if err != nil {
+		t.Fatalf("could not rename admin kubeconfig: %v", err)
+	}
+
+	existing, err := parseKubeconfig([]byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://example.com
+  name: other
+contexts:
+- context:
+    cluster: other
+    user: other
+  name: other
+current-context: other
+preferences: {}
+users:
+- name: other
+  user:
+    token: abc
+`))
+	if err != nil {
+		t.Fatalf("could not parse existing kubeconfig: %v", err)
+	}
+
+	// Merging twice should not duplicate entries
+	mergeKubeconfig(existing, admin)
+	mergeKubeconfig(existing, admin)
+
+	for _, section := range []string{"clusters", "contexts", "users"} {
+		if len(existing.namedlist(section)) != 2 {
+			t.Fatalf("expected 2 %v after merge, got %v", section, len(existing.namedlist(section)))
+		}
+	}
+
+	if existing["current-context"] != "other" {
+		t.Fatalf("merge changed current context to '%v'", existing["current-context"])
+	}
+
+	clusterentry := existing.namedlist("clusters")[1].(map[string]interface{})
+	if clusterentry["name"] != "kutti-dev" {
+		t.Fatalf("expected cluster 'kutti-dev', got '%v'", clusterentry["name"])
+	}
+	server := clusterentry["cluster"].(map[string]interface{})["server"]
+	if server != "https://127.0.0.1:16443" {
+		t.Fatalf("expected server to be rewritten, got '%v'", server)
+	}
+
+	if !removeKubeconfigEntries(existing, "dev") {
+		t.Fatalf("expected entries for 'dev' to be removed")
+	}
+
+	for _, section := range []string{"clusters", "contexts", "users"} {
+		if len(existing.namedlist(section)) != 1 {
+			t.Fatalf("expected 1 %v after remove, got %v", section, len(existing.namedlist(section)))
+		}
+	}
+
+	if removeKubeconfigEntries(existing, "dev") {
+		t.Fatalf("expected nothing to remove the second time")
+	}
+}
+
+func TestSSHConfigSection(t *testing.T) {
+	hosts := []*sshhost{
+		{alias: sshHostAlias("dev", "control"), nodename: "control", hostname: "localhost", port: "10001"},
+		{alias: sshHostAlias("dev", "worker2"), nodename: "worker2", hostname: "192.168.1.5", port: "22"},
+	}
+	section := renderSSHConfig("dev", hosts, []string{"worker1"}, "kuttiadmin", "/keys/dev/id_ed25519")
+
+	// Host key checking is only disabled for the loopback address
+	if strings.Count(section, "StrictHostKeyChecking no\n") != 1 ||
+		!strings.Contains(section, "Port 10001\n    User kuttiadmin\n    IdentityFile /keys/dev/id_ed25519\n    IdentitiesOnly yes\n    StrictHostKeyChecking no\n") {
+		t.Fatalf("expected host key checking disabled only for localhost, got:\n%v", section)
+	}
+
+	existing := "# Managed by kutti\n"
+	content, found := replaceSSHConfigSection(existing, "dev", section)
+	if found {
+		t.Fatalf("expected no existing section")
+	}
+
+	// Installing twice, or after another cluster, should not duplicate
+	// the section
+	content, _ = replaceSSHConfigSection(content, "test", renderSSHConfig("test", nil, nil, "u", "k"))
+	content, found = replaceSSHConfigSection(content, "dev", section)
+	if !found {
+		t.Fatalf("expected existing section")
+	}
+	if strings.Count(content, "Host kutti-dev-control\n") != 1 {
+		t.Fatalf("expected one Host block, got:\n%v", content)
+	}
+	if !strings.HasPrefix(content, existing) || !strings.Contains(content, "# Node worker1:") {
+		t.Fatalf("unexpected content:\n%v", content)
+	}
+
+	content, found = replaceSSHConfigSection(content, "dev", "")
+	if !found || strings.Contains(content, "kutti-dev") || !strings.Contains(content, "# END kutti cluster test\n") {
+		t.Fatalf("expected only the dev section to be removed, got:\n%v", content)
+	}
+
+	testCases := []struct {
+		config   string
+		expected bool
+	}{
+		{config: "", expected: false},
+		{config: "Host *\n    ServerAliveInterval 60\n", expected: false},
+		{config: "Include kutti_config\n", expected: true},
+		{config: "include ~/.ssh/kutti_config\n", expected: true},
+		{config: "Include config.d/* \"kutti_config\"\n", expected: true},
+		{config: "Include other_kutti_config\n", expected: false},
+	}
+
+	for _, tc := range testCases {
+		if hasSSHInclude(tc.config) != tc.expected {
+			t.Fatalf("config %q: expected %v", tc.config, tc.expected)
+		}
+	}
+}
+
+func TestAnsibleInventory(t *testing.T) {
+	hosts := []*inventoryhost{
+		{name: "kutti-dev-control", cluster: "dev", node: "control", role: rolecontrolplane, host: "localhost", port: 10001, user: "kuttiadmin", identityfile: "/keys/dev key"},
+		{name: "kutti-dev-worker1", cluster: "dev", node: "worker1", role: roleworker, host: "localhost", port: 10002, user: "kuttiadmin", identityfile: "/keys/dev key"},
+		{name: "kutti-test-node1", cluster: "test", node: "node1", host: "192.168.1.5", port: 22, user: "admin", identityfile: "/keys/test"},
+	}
+
+	inventory := ansibleInventory(hosts)
+
+	expectedgroups := map[string][]string{
+		"kutti_dev":               {"kutti-dev-control", "kutti-dev-worker1"},
+		"kutti_test":              {"kutti-test-node1"},
+		"kutti_role_controlplane": {"kutti-dev-control"},
+		"kutti_role_worker":       {"kutti-dev-worker1"},
+	}
+	for name, expected := range expectedgroups {
+		group, ok := inventory[name].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected group '%v'", name)
+		}
+		if strings.Join(group["hosts"].([]string), ",") != strings.Join(expected, ",") {
+			t.Fatalf("group '%v': expected %v, got %v", name, expected, group["hosts"])
+		}
+	}
+
+	children := inventory["kutti"].(map[string]interface{})["children"].([]string)
+	if strings.Join(children, ",") != "kutti_dev,kutti_test" {
+		t.Fatalf("expected cluster groups as children of kutti, got %v", children)
+	}
+
+	hostvars := inventory["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	vars := hostvars["kutti-test-node1"].(map[string]interface{})
+	if vars["ansible_host"] != "192.168.1.5" || vars["ansible_port"] != 22 || vars["ansible_user"] != "admin" {
+		t.Fatalf("unexpected host variables: %v", vars)
+	}
+	if _, ok := vars["kutti_role"]; ok {
+		t.Fatalf("expected no role for a node of an unmanaged cluster")
+	}
+	if _, ok := vars["ansible_ssh_common_args"]; ok {
+		t.Fatalf("expected host key checking for a node not on the loopback address")
+	}
+	if _, ok := hostvars["kutti-dev-control"].(map[string]interface{})["ansible_ssh_common_args"]; !ok {
+		t.Fatalf("expected host key checking disabled for a node on the loopback address")
+	}
+
+	ini := iniInventory(hosts)
+	for _, expected := range []string{
+		"[kutti_dev]\nkutti-dev-control ansible_host=localhost ansible_port=10001 ",
+		`ansible_ssh_private_key_file="/keys/dev key"`,
+		"[kutti_role_worker]\nkutti-dev-worker1\n",
+		"[kutti:children]\nkutti_dev\nkutti_test\n",
+	} {
+		if !strings.Contains(ini, expected) {
+			t.Fatalf("expected INI inventory to contain %q, got:\n%v", expected, ini)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	testCases := []struct {
+		value string
+		low   int
+		high  int
+		valid bool
+	}{
+		{value: "10000-19999", low: 10000, high: 19999, valid: true},
+		{value: " 20000 - 20000 ", low: 20000, high: 20000, valid: true},
+		{value: "10000", valid: false},
+		{value: "0-100", valid: false},
+		{value: "20000-10000", valid: false},
+		{value: "10000-70000", valid: false},
+	}
+
+	for _, tc := range testCases {
+		low, high, err := parsePortRange(tc.value)
+		if !tc.valid {
+			if err == nil {
+				t.Fatalf("range '%v': expected error", tc.value)
+			}
+			continue
+		}
+
+		if err != nil || low != tc.low || high != tc.high {
+			t.Fatalf("range '%v': expected %v-%v, got %v-%v (%v)", tc.value, tc.low, tc.high, low, high, err)
+		}
+	}
+}
+
+func TestHostPortFree(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	if hostPortFree(port) {
+		t.Fatalf("expected port %v to be in use", port)
+	}
+
+	listener.Close()
+	if !hostPortFree(port) {
+		t.Fatalf("expected port %v to be free", port)
+	}
+}
+
+func TestFlannelRelease(t *testing.T) {
+	testCases := []struct {
+		k8sversion string
+		expected   string
+	}{
+		{k8sversion: "1.22", expected: "v0.22.3"},
+		{k8sversion: "1.26", expected: "v0.22.3"},
+		{k8sversion: "1.28", expected: "v0.24.4"},
+		{k8sversion: "v1.29.3", expected: "v0.25.7"},
+		{k8sversion: "1.33", expected: "v0.26.1"},
+		{k8sversion: "bad", expected: "v0.22.3"},
+	}
+
+	for _, tc := range testCases {
+		actual := flannelRelease(tc.k8sversion)
+		if actual != tc.expected {
+			t.Fatalf("%v: expected Flannel %v, got %v", tc.k8sversion, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilib"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+)
+
+const (
+	// Global setting for the range of host ports chosen automatically,
+	// as LOW-HIGH.
+	portrangesetting = "port-range"
+	defaultportrange = "10000-19999"
+
+	// The SSH port on nodes.
+	nodesshport = 22
+)
+
+// portRange returns the range of host ports chosen automatically.
+func portRange() (int, int, error) {
+	value, ok := cli.Setting(portrangesetting)
+	if !ok || value == "" {
+		value = defaultportrange
+	}
+
+	return parsePortRange(value)
+}
+
+func parsePortRange(value string) (int, int, error) {
+	lowstring, highstring, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid %v setting '%v': expected LOW-HIGH", portrangesetting, value)
+	}
+
+	low, err := strconv.Atoi(strings.TrimSpace(lowstring))
+	if err != nil || !kuttilib.ValidPort(low) {
+		return 0, 0, fmt.Errorf("invalid %v setting '%v': invalid port '%v'", portrangesetting, value, lowstring)
+	}
+
+	high, err := strconv.Atoi(strings.TrimSpace(highstring))
+	if err != nil || !kuttilib.ValidPort(high) {
+		return 0, 0, fmt.Errorf("invalid %v setting '%v': invalid port '%v'", portrangesetting, value, highstring)
+	}
+
+	if high < low {
+		return 0, 0, fmt.Errorf("invalid %v setting '%v': %v is less than %v", portrangesetting, value, high, low)
+	}
+
+	return low, high, nil
+}
+
+// usedHostPorts returns the host ports forwarded to nodes of all kutti
+// clusters.
+func usedHostPorts() map[int]bool {
+	result := map[int]bool{}
+	for _, claim := range HostPortClaims() {
+		result[claim.HostPort] = true
+	}
+
+	return result
+}
+
+// hostPortFree reports whether nothing on the host is listening on a port.
+// A dial catches listeners on any address, and a listen on the loopback
+// address catches ports that are bound but not listening. Only loopback is
+// used, so that no firewall prompt is triggered.
+func hostPortFree(port int) bool {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", address, 200*time.Millisecond)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+
+	return true
+}
+
+// hostPortAvailable reports whether a host port can be used by a node of
+// the specified cluster.
+func hostPortAvailable(cluster *kuttilib.Cluster, port int, used map[int]bool) bool {
+	return !used[port] && cluster.CheckHostPort(port) == nil && hostPortFree(port)
+}
+
+// findFreeHostPorts returns the specified number of host ports, scanning
+// upwards from start and stopping after end, that are free on the host
+// and unused by any kutti cluster.
+func findFreeHostPorts(cluster *kuttilib.Cluster, start int, end int, count int) ([]int, error) {
+	used := usedHostPorts()
+
+	result := make([]int, 0, count)
+	for port := start; len(result) < count; port++ {
+		if port > end || !kuttilib.ValidPort(port) {
+			return nil, fmt.Errorf(
+				"could not find %v free host ports between %v and %v",
+				count,
+				start,
+				end,
+			)
+		}
+
+		if hostPortAvailable(cluster, port, used) {
+			result = append(result, port)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,660 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kuttiproject/kuttilib"
+	"github.com/kuttiproject/kuttilog"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
+)
+
+// clusterspec describes the desired state of a cluster.
+type clusterspec struct {
+	Name       string      `json:"name" yaml:"name"`
+	Driver     string      `json:"driver" yaml:"driver"`
+	K8sVersion string      `json:"k8sversion" yaml:"k8sversion"`
+	Managed    bool        `json:"managed" yaml:"managed"`
+	Nodes      []*nodespec `json:"nodes" yaml:"nodes"`
+}
+
+// nodespec describes the desired state of a node. An SSHPort of zero
+// means that a free host port should be found if the driver needs one.
+type nodespec struct {
+	Name         string      `json:"name" yaml:"name"`
+	ControlPlane bool        `json:"controlplane,omitempty" yaml:"controlplane,omitempty"`
+	SSHPort      int         `json:"sshport,omitempty" yaml:"sshport,omitempty"`
+	Ports        []*portspec `json:"ports,omitempty" yaml:"ports,omitempty"`
+}
+
+// portspec describes a node port published to a host port.
+type portspec struct {
+	NodePort int `json:"nodeport" yaml:"nodeport"`
+	HostPort int `json:"hostport" yaml:"hostport"`
+}
+
+// specaction is a single step needed to bring a cluster to the state
+// described by a spec.
+type specaction struct {
+	Action  string
+	Target  string
+	Details string
+	run     func() error
+}
+
+// parseClusterSpec parses and validates a cluster spec. Since JSON is a
+// subset of YAML, both formats are accepted.
+func parseClusterSpec(data []byte) (*clusterspec, error) {
+	spec := &clusterspec{}
+	err := yaml.Unmarshal(data, spec)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse cluster spec: %v", err)
+	}
+
+	err = spec.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+func (spec *clusterspec) validate() error {
+	if spec.Name == "" {
+		return fmt.Errorf("cluster spec does not specify a name")
+	}
+
+	if spec.Driver == "" {
+		return fmt.Errorf("cluster spec does not specify a driver")
+	}
+
+	if spec.K8sVersion == "" {
+		return fmt.Errorf("cluster spec does not specify a k8sversion")
+	}
+
+	nodenames := map[string]bool{}
+	hostports := map[int]string{}
+	controlplanes := 0
+	for _, node := range spec.Nodes {
+		if node.Name == "" {
+			return fmt.Errorf("cluster spec has a node with no name")
+		}
+
+		if nodenames[node.Name] {
+			return fmt.Errorf("node '%v' specified more than once", node.Name)
+		}
+		nodenames[node.Name] = true
+
+		if node.ControlPlane {
+			controlplanes++
+		}
+
+		if node.SSHPort != 0 {
+			if !kuttilib.ValidPort(node.SSHPort) {
+				return fmt.Errorf("node '%v': invalid sshport %v", node.Name, node.SSHPort)
+			}
+
+			if other, ok := hostports[node.SSHPort]; ok {
+				return fmt.Errorf("node '%v': host port %v already used by node '%v'", node.Name, node.SSHPort, other)
+			}
+			hostports[node.SSHPort] = node.Name
+		}
+
+		nodeports := map[int]bool{}
+		for _, port := range node.Ports {
+			if !kuttilib.ValidPort(port.NodePort) || !kuttilib.ValidPort(port.HostPort) {
+				return fmt.Errorf(
+					"node '%v': invalid port mapping %v:%v. Valid ports are between 1 and 65535",
+					node.Name,
+					port.HostPort,
+					port.NodePort,
+				)
+			}
+
+			if port.NodePort == nodesshport {
+				return fmt.Errorf("node '%v': use sshport to forward the SSH port", node.Name)
+			}
+
+			if nodeports[port.NodePort] {
+				return fmt.Errorf("node '%v': node port %v published more than once", node.Name, port.NodePort)
+			}
+			nodeports[port.NodePort] = true
+
+			if other, ok := hostports[port.HostPort]; ok {
+				return fmt.Errorf("node '%v': host port %v already used by node '%v'", node.Name, port.HostPort, other)
+			}
+			hostports[port.HostPort] = node.Name
+		}
+	}
+
+	if spec.Managed && controlplanes != 1 {
+		return fmt.Errorf("a managed cluster spec must have exactly one control plane node")
+	}
+
+	if !spec.Managed && controlplanes != 0 {
+		return fmt.Errorf("an unmanaged cluster spec cannot have a control plane node")
+	}
+
+	return nil
+}
+
+func (spec *clusterspec) controlplane() *nodespec {
+	for _, node := range spec.Nodes {
+		if node.ControlPlane {
+			return node
+		}
+	}
+
+	return nil
+}
+
+func loadClusterSpec(filename string) (*clusterspec, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseClusterSpec(data)
+}
+
+// exportClusterSpec generates a spec from an existing cluster.
+func exportClusterSpec(cluster *kuttilib.Cluster) *clusterspec {
+	controlplane, managed := ControlPlaneNode(cluster)
+
+	spec := &clusterspec{
+		Name:       cluster.Name(),
+		Driver:     cluster.DriverName(),
+		K8sVersion: cluster.K8sVersion(),
+		Managed:    managed,
+		Nodes:      []*nodespec{},
+	}
+
+	nodenames := cluster.NodeNames()
+	sort.Strings(nodenames)
+	for _, nodename := range nodenames {
+		node, _ := cluster.GetNode(nodename)
+		nodedata := &nodespec{
+			Name:         nodename,
+			ControlPlane: managed && controlplane.Name() == nodename,
+			Ports:        []*portspec{},
+		}
+
+		for nodeport, hostport := range node.Ports() {
+			if nodeport == nodesshport {
+				nodedata.SSHPort = hostport
+				continue
+			}
+
+			nodedata.Ports = append(
+				nodedata.Ports,
+				&portspec{NodePort: nodeport, HostPort: hostport},
+			)
+		}
+		sort.Slice(nodedata.Ports, func(i, j int) bool {
+			return nodedata.Ports[i].NodePort < nodedata.Ports[j].NodePort
+		})
+
+		if nodedata.ControlPlane {
+			spec.Nodes = append([]*nodespec{nodedata}, spec.Nodes...)
+		} else {
+			spec.Nodes = append(spec.Nodes, nodedata)
+		}
+	}
+
+	return spec
+}
+
+func renderClusterSpec(spec *clusterspec, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(spec)
+	case "json":
+		result, err := json.MarshalIndent(spec, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(result, '\n'), nil
+	}
+
+	return nil, fmt.Errorf("unknown format '%v'. Use yaml or json", format)
+}
+
+// speccluster fetches the cluster named in a spec. It is called when
+// actions are run, since the cluster may be created by an earlier action.
+func speccluster(spec *clusterspec) (*kuttilib.Cluster, error) {
+	cluster, ok := kuttilib.GetCluster(spec.Name)
+	if !ok {
+		return nil, fmt.Errorf("cluster '%v' not found", spec.Name)
+	}
+
+	return cluster, nil
+}
+
+func specnode(spec *clusterspec, nodename string) (*kuttilib.Cluster, *kuttilib.Node, error) {
+	cluster, err := speccluster(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	node, ok := cluster.GetNode(nodename)
+	if !ok {
+		return nil, nil, fmt.Errorf("node '%v' not found", nodename)
+	}
+
+	return cluster, node, nil
+}
+
+// specsshport returns the SSH host port for a node, finding a free one
+// if the spec does not specify it. It returns 0 if the driver does not
+// need SSH port forwarding.
+func specsshport(cluster *kuttilib.Cluster, node *nodespec) (int, error) {
+	if !cluster.Driver().UsesNATNetworking() {
+		return 0, nil
+	}
+
+	if node.SSHPort != 0 {
+		return node.SSHPort, cluster.CheckHostPort(node.SSHPort)
+	}
+
+	return FindFreeHostPort(cluster)
+}
+
+func sshportdetails(node *nodespec) string {
+	if node.SSHPort == 0 {
+		return "SSH port: auto"
+	}
+
+	return fmt.Sprintf("SSH port: %v", node.SSHPort)
+}
+
+func specPublishAction(spec *clusterspec, nodename string, port *portspec) *specaction {
+	return &specaction{
+		Action:  "publish",
+		Target:  nodename,
+		Details: fmt.Sprintf("node port %v to host port %v", port.NodePort, port.HostPort),
+		run: func() error {
+			cluster, node, err := specnode(spec, nodename)
+			if err != nil {
+				return err
+			}
+
+			err = cluster.CheckHostPort(port.HostPort)
+			if err != nil {
+				return fmt.Errorf("cannot forward to host port %v: %v", port.HostPort, err)
+			}
+
+			return node.ForwardPort(port.HostPort, port.NodePort)
+		},
+	}
+}
+
+func specUnpublishAction(spec *clusterspec, nodename string, nodeport int, hostport int) *specaction {
+	return &specaction{
+		Action:  "unpublish",
+		Target:  nodename,
+		Details: fmt.Sprintf("node port %v from host port %v", nodeport, hostport),
+		run: func() error {
+			_, node, err := specnode(spec, nodename)
+			if err != nil {
+				return err
+			}
+
+			return node.UnforwardPort(nodeport)
+		},
+	}
+}
+
+// lazyClusterClient returns a function that creates the SSH client of a
+// cluster when first called. Creating the client can generate the cluster
+// key, which must not happen while only planning.
+func lazyClusterClient(clustername string) func() (*remote.Client, error) {
+	var client *remote.Client
+	return func() (*remote.Client, error) {
+		if client != nil {
+			return client, nil
+		}
+
+		var err error
+		client, err = newClusterClient(nil, clustername)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up SSH key: %v", err)
+		}
+
+		return client, nil
+	}
+}
+
+// planClusterSpec compares a spec with the current state of the cluster
+// it names, and returns the actions needed to make them match.
+func planClusterSpec(spec *clusterspec) ([]*specaction, error) {
+	sshclient := lazyClusterClient(spec.Name)
+
+	actions := []*specaction{}
+	publishactions := []*specaction{}
+
+	cluster, exists := kuttilib.GetCluster(spec.Name)
+	if exists {
+		if cluster.DriverName() != spec.Driver {
+			return nil, fmt.Errorf(
+				"cluster '%v' uses driver '%v'. The driver of an existing cluster cannot be changed",
+				spec.Name,
+				cluster.DriverName(),
+			)
+		}
+
+		if cluster.K8sVersion() != spec.K8sVersion {
+			return nil, fmt.Errorf(
+				"cluster '%v' uses Kubernetes version '%v'. The version of an existing cluster cannot be changed",
+				spec.Name,
+				cluster.K8sVersion(),
+			)
+		}
+
+		controlplane, managed := ControlPlaneNode(cluster)
+		if managed != spec.Managed {
+			return nil, fmt.Errorf(
+				"cluster '%v' cannot be changed between managed and unmanaged",
+				spec.Name,
+			)
+		}
+
+		if managed && controlplane.Name() != spec.controlplane().Name {
+			return nil, fmt.Errorf(
+				"the control plane node of cluster '%v' is '%v', and cannot be changed",
+				spec.Name,
+				controlplane.Name(),
+			)
+		}
+	} else {
+		err := kuttilib.ValidateClusterName(spec.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		driver, ok := kuttilib.GetDriver(spec.Driver)
+		if !ok {
+			return nil, fmt.Errorf("driver '%v' not found", spec.Driver)
+		}
+
+		version, err := driver.GetVersion(spec.K8sVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		if version.Status() != kuttilib.VersionStatusDownloaded {
+			return nil, fmt.Errorf(
+				"local copy of image '%v' has not been downloaded. Cannot create cluster",
+				spec.K8sVersion,
+			)
+		}
+
+		actions = append(actions, &specaction{
+			Action:  "create",
+			Target:  spec.Name,
+			Details: fmt.Sprintf("cluster with driver %v, Kubernetes %v", spec.Driver, spec.K8sVersion),
+			run: func() error {
+				return kuttilib.NewEmptyCluster(spec.Name, spec.K8sVersion, spec.Driver)
+			},
+		})
+	}
+
+	// Delete nodes which are not in the spec
+	wanted := map[string]*nodespec{}
+	for _, node := range spec.Nodes {
+		wanted[node.Name] = node
+	}
+
+	if exists {
+		nodenames := cluster.NodeNames()
+		sort.Strings(nodenames)
+		for _, nodename := range nodenames {
+			if _, ok := wanted[nodename]; ok {
+				continue
+			}
+
+			actions = append(actions, &specaction{
+				Action:  "delete",
+				Target:  nodename,
+				Details: "node",
+				run: func() error {
+					cluster, err := speccluster(spec)
+					if err != nil {
+						return err
+					}
+
+					if controlplane, ok := ControlPlaneNode(cluster); ok {
+						client, err := sshclient()
+						if err == nil {
+							err = removeWorker(client, controlplane, nodename)
+						}
+						if err != nil {
+							kuttilog.Printf(
+								kuttilog.Quiet,
+								"Warning: could not remove node '%v' from Kubernetes: %v.",
+								nodename,
+								err,
+							)
+						}
+					}
+
+					return cluster.DeleteNode(nodename, true)
+				},
+			})
+		}
+	}
+
+	// Create nodes which are not in the cluster, and reconcile
+	// published ports of the ones which are
+	newnodes := []*nodespec{}
+	for _, node := range spec.Nodes {
+		var existing *kuttilib.Node
+		if exists {
+			existing, _ = cluster.GetNode(node.Name)
+		}
+
+		if existing == nil {
+			newnodes = append(newnodes, node)
+			for _, port := range node.Ports {
+				publishactions = append(publishactions, specPublishAction(spec, node.Name, port))
+			}
+			continue
+		}
+
+		currentports := existing.Ports()
+		if node.SSHPort != 0 && currentports[nodesshport] != node.SSHPort {
+			kuttilog.Printf(
+				kuttilog.Quiet,
+				"Warning: SSH port of existing node '%v' cannot be changed from %v to %v. Ignoring.",
+				node.Name,
+				currentports[nodesshport],
+				node.SSHPort,
+			)
+		}
+
+		desiredports := map[int]int{}
+		for _, port := range node.Ports {
+			desiredports[port.NodePort] = port.HostPort
+			hostport, ok := currentports[port.NodePort]
+			if ok && hostport == port.HostPort {
+				continue
+			}
+
+			if ok {
+				actions = append(actions, specUnpublishAction(spec, node.Name, port.NodePort, hostport))
+			}
+			publishactions = append(publishactions, specPublishAction(spec, node.Name, port))
+		}
+
+		nodeports := make([]int, 0, len(currentports))
+		for nodeport := range currentports {
+			nodeports = append(nodeports, nodeport)
+		}
+		sort.Ints(nodeports)
+		for _, nodeport := range nodeports {
+			_, ok := desiredports[nodeport]
+			if nodeport == nodesshport || ok {
+				continue
+			}
+
+			actions = append(actions, specUnpublishAction(spec, node.Name, nodeport, currentports[nodeport]))
+		}
+	}
+
+	for _, node := range newnodes {
+		actions = append(actions, &specaction{
+			Action:  "create",
+			Target:  node.Name,
+			Details: "node, " + sshportdetails(node),
+			run: func() error {
+				cluster, err := speccluster(spec)
+				if err != nil {
+					return err
+				}
+
+				sshport, err := specsshport(cluster, node)
+				if err != nil {
+					return fmt.Errorf("cannot use host port for SSH: %v", err)
+				}
+
+				if spec.Managed {
+					client, err := sshclient()
+					if err != nil {
+						return err
+					}
+
+					_, err = createAndStartNode(cluster, client, node.Name, sshport)
+					return err
+				}
+
+				newnode, err := cluster.NewUninitializedNode(node.Name)
+				if err != nil {
+					return err
+				}
+
+				if sshport != 0 {
+					return newnode.ForwardSSHPort(sshport)
+				}
+
+				return nil
+			},
+		})
+	}
+
+	if spec.Managed {
+		controlplanename := spec.controlplane().Name
+		if !exists {
+			actions = append(actions, &specaction{
+				Action:  "init",
+				Target:  controlplanename,
+				Details: "control plane",
+				run: func() error {
+					_, controlplane, err := specnode(spec, controlplanename)
+					if err != nil {
+						return err
+					}
+
+					client, err := sshclient()
+					if err != nil {
+						return err
+					}
+
+					_, err = initControlPlane(client, controlplane)
+					if err != nil {
+						return err
+					}
+
+					return cli.SetClusterSetting(spec.Name, controlplanesetting, controlplanename)
+				},
+			})
+		}
+
+		for _, node := range newnodes {
+			if node.ControlPlane {
+				continue
+			}
+
+			actions = append(actions, &specaction{
+				Action:  "join",
+				Target:  node.Name,
+				Details: "worker to control plane " + controlplanename,
+				run: func() error {
+					_, controlplane, err := specnode(spec, controlplanename)
+					if err != nil {
+						return err
+					}
+
+					_, worker, err := specnode(spec, node.Name)
+					if err != nil {
+						return err
+					}
+
+					client, err := sshclient()
+					if err != nil {
+						return err
+					}
+
+					joincommand, err := joinCommand(client, controlplane)
+					if err != nil {
+						return err
+					}
+
+					return joinWorker(client, worker, joincommand)
+				},
+			})
+		}
+	}
+
+	return append(actions, publishactions...), nil
+}
+
+// summarizeSpecActions returns a one-line summary of a plan.
+func summarizeSpecActions(actions []*specaction) string {
+	counts := map[string]int{}
+	order := []string{}
+	for _, action := range actions {
+		if counts[action.Action] == 0 {
+			order = append(order, action.Action)
+		}
+		counts[action.Action]++
+	}
+
+	parts := make([]string, 0, len(order))
+	for _, action := range order {
+		parts = append(parts, fmt.Sprintf("%v to %v", counts[action], action))
+	}
+
+	return "Plan: " + strings.Join(parts, ", ") + "."
+}
+
+// applySpecActions runs the actions in a plan in order, stopping at the
+// first failure.
+func applySpecActions(actions []*specaction) error {
+	for _, action := range actions {
+		kuttilog.Printf(
+			kuttilog.Info,
+			"%v %v: %v...",
+			strings.ToUpper(action.Action[:1])+action.Action[1:],
+			action.Target,
+			action.Details,
+		)
+
+		err := action.run()
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"could not %v %v: %v",
+				action.Action,
+				action.Target,
+				err,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/kuttiproject/kuttilib"
+)
+
+const (
+	// The managed file, in ~/.ssh, that holds Host blocks for kutti nodes.
+	sshconfigincludename = "kutti_config"
+	sshconfigbeginmarker = "# BEGIN kutti cluster "
+	sshconfigendmarker   = "# END kutti cluster "
+)
+
+// sshhost is a Host block for a node.
+type sshhost struct {
+	alias    string
+	nodename string
+	hostname string
+	port     string
+}
+
+func sshHostAlias(clustername string, nodename string) string {
+	return "kutti-" + clustername + "-" + nodename
+}
+
+// clusterSSHHosts returns Host blocks for the nodes of a cluster, in name
+// order, and the names of nodes whose SSH address could not be found.
+func clusterSSHHosts(cluster *kuttilib.Cluster) ([]*sshhost, []string) {
+	nodenames := cluster.NodeNames()
+	sort.Strings(nodenames)
+
+	hosts := make([]*sshhost, 0, len(nodenames))
+	skipped := []string{}
+	for _, nodename := range nodenames {
+		node, ok := cluster.GetNode(nodename)
+		if !ok {
+			continue
+		}
+
+		hostname, port, err := net.SplitHostPort(node.SSHAddress())
+		if err != nil {
+			skipped = append(skipped, nodename)
+			continue
+		}
+
+		hosts = append(hosts, &sshhost{
+			alias:    sshHostAlias(cluster.Name(), nodename),
+			nodename: nodename,
+			hostname: hostname,
+			port:     port,
+		})
+	}
+
+	return hosts, skipped
+}
+
+// renderSSHConfig returns the Host blocks of a cluster as an OpenSSH
+// client configuration section, between markers that allow it to be
+// found and replaced later. Skipped nodes are noted in comments.
+func renderSSHConfig(clustername string, hosts []*sshhost, skipped []string, username string, identityfile string) string {
+	knownhosts := nullKnownHostsFile()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v%v\n", sshconfigbeginmarker, clustername)
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "Host %v\n", host.alias)
+		fmt.Fprintf(&b, "    HostName %v\n", host.hostname)
+		fmt.Fprintf(&b, "    Port %v\n", host.port)
+		fmt.Fprintf(&b, "    User %v\n", username)
+		fmt.Fprintf(&b, "    IdentityFile %v\n", sshConfigQuote(identityfile))
+		fmt.Fprintf(&b, "    IdentitiesOnly yes\n")
+		if skipHostKeyCheck(host.hostname) {
+			fmt.Fprintf(&b, "    StrictHostKeyChecking no\n")
+			fmt.Fprintf(&b, "    UserKnownHostsFile %v\n", knownhosts)
+			fmt.Fprintf(&b, "    LogLevel ERROR\n")
+		}
+	}
+	for _, nodename := range skipped {
+		fmt.Fprintf(&b, "# Node %v: SSH address not available\n", nodename)
+	}
+	fmt.Fprintf(&b, "%v%v\n", sshconfigendmarker, clustername)
+
+	return b.String()
+}
+
+// skipHostKeyCheck reports whether host key checking should be disabled
+// for a node address. Nodes of drivers that use NAT networking are
+// reached through forwarded ports on the loopback address, which are
+// reused by VMs with different host keys. Other addresses are checked as
+// usual.
+func skipHostKeyCheck(hostname string) bool {
+	if hostname == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// nullKnownHostsFile returns the null device, for use as the known hosts
+// file of nodes reached through the loopback address.
+func nullKnownHostsFile() string {
+	if runtime.GOOS == "windows" {
+		return "NUL"
+	}
+
+	return "/dev/null"
+}
+
+// sshConfigQuote quotes a value in an OpenSSH configuration file if it
+// contains spaces.
+func sshConfigQuote(value string) string {
+	if strings.ContainsAny(value, " \t") {
+		return `"` + value + `"`
+	}
+
+	return value
+}
+
+// replaceSSHConfigSection replaces the section of a cluster in the
+// content of the managed file, or appends it if it is not there. An
+// empty section removes the existing one. It also reports whether an
+// existing section was found.
+func replaceSSHConfigSection(content string, clustername string, section string) (string, bool) {
+	beginline := sshconfigbeginmarker + clustername
+	endline := sshconfigendmarker + clustername
+
+	var result []string
+	found := false
+	insection := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !insection && strings.TrimSpace(line) == beginline:
+			insection = true
+			if !found && section != "" {
+				result = append(result, strings.TrimSuffix(section, "\n"))
+			}
+			found = true
+		case insection && strings.TrimSpace(line) == endline:
+			insection = false
+		case !insection:
+			result = append(result, line)
+		}
+	}
+
+	if !found && section != "" {
+		result = append(result, strings.TrimSuffix(section, "\n"))
+	}
+
+	if len(result) == 0 {
+		return "", found
+	}
+
+	return strings.Join(result, "\n") + "\n", found
+}
+
+// hasSSHInclude reports whether an OpenSSH client configuration already
+// includes the managed file.
+func hasSSHInclude(content string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "Include") {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			field = strings.Trim(field, `"`)
+			if field == sshconfigincludename || filepath.Base(field) == sshconfigincludename {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sshConfigPaths returns the paths of the user's OpenSSH client
+// configuration and of the managed file.
+func sshConfigPaths() (string, string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	sshdir := filepath.Join(home, ".ssh")
+	return filepath.Join(sshdir, "config"), filepath.Join(sshdir, sshconfigincludename), nil
+}
+
+func readFileIfExists(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	return string(data), err
+}
+
+// installSSHConfig writes the section of a cluster to the managed file,
+// replacing any earlier one, and makes sure that the user's OpenSSH
+// client configuration includes the managed file. It returns the path of
+// the managed file.
+func installSSHConfig(clustername string, section string) (string, error) {
+	configpath, includepath, err := sshConfigPaths()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(includepath), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := readFileIfExists(includepath)
+	if err != nil {
+		return "", err
+	}
+
+	if content == "" {
+		content = "# Managed by kutti. Use 'kutti cluster ssh-config --install' to update.\n"
+	}
+	content, _ = replaceSSHConfigSection(content, clustername, section)
+	err = os.WriteFile(includepath, []byte(content), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	config, err := readFileIfExists(configpath)
+	if err != nil {
+		return "", err
+	}
+
+	if !hasSSHInclude(config) {
+		// Include must come before any Host block, or it applies only
+		// to that block.
+		config = "# Added by kutti\nInclude " + sshconfigincludename + "\n\n" + config
+		err = os.WriteFile(configpath, []byte(config), 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return includepath, nil
+}
+
+// removeSSHConfig removes the section of a cluster from the managed file,
+// and reports whether it was there.
+func removeSSHConfig(clustername string) (bool, error) {
+	_, includepath, err := sshConfigPaths()
+	if err != nil {
+		return false, err
+	}
+
+	content, err := readFileIfExists(includepath)
+	if err != nil || content == "" {
+		return false, err
+	}
+
+	content, found := replaceSSHConfigSection(content, clustername, "")
+	if !found {
+		return false, nil
+	}
+
+	return true, os.WriteFile(includepath, []byte(content), 0600)
+}
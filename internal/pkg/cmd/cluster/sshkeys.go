@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/kuttiproject/workspace"
+
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
+)
+
+const (
+	sshkeysdirname = "keys"
+	sshkeyfilename = "id_ed25519"
+	sshkeycomment  = "kutti-"
+)
+
+// clusterKeyDir returns the workspace directory that holds the SSH key
+// pair of a cluster.
+func clusterKeyDir(clustername string) (string, error) {
+	keysdir, err := workspace.Configsubdir(sshkeysdirname)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(keysdir, clustername), nil
+}
+
+// ensureClusterKey returns the path of the SSH private key of a cluster,
+// generating the key pair if it does not exist. Clusters created by
+// older versions of kutti get their key this way.
+func ensureClusterKey(clustername string) (string, error) {
+	keydir, err := clusterKeyDir(clustername)
+	if err != nil {
+		return "", err
+	}
+
+	keyfile := filepath.Join(keydir, sshkeyfilename)
+	err = remote.EnsureKey(keyfile, sshkeycomment+clustername)
+	if err != nil {
+		return "", err
+	}
+
+	return keyfile, nil
+}
+
+// removeClusterKey deletes the SSH key pair of a cluster.
+func removeClusterKey(clustername string) error {
+	keydir, err := clusterKeyDir(clustername)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(keydir)
+}
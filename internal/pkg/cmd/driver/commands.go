@@ -38,16 +38,39 @@ var drivercommand = &cli.Command{
 		},
 		{
 			Cmd: &cobra.Command{
-				Use:                   "update DRIVERNAME",
-				Aliases:               []string{"updateimages"},
-				Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
-				ValidArgsFunction:     DrivernameValidArgs,
-				Short:                 "Update image list for this driver",
-				Long:                  "Update image list for this driver.",
-				RunE:                  driverUpdateCommand,
-				DisableFlagsInUseLine: true,
-				SilenceErrors:         true,
+				Use:               "update DRIVERNAME",
+				Aliases:           []string{"updateimages"},
+				Args:              cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+				ValidArgsFunction: DrivernameValidArgs,
+				Short:             "Update image list for this driver",
+				Long: `
+Update image list for this driver.
+
+By default, the driver updates its image list from its public source. If a
+version catalog is specified with --catalog, or with the catalog-DRIVERNAME
+setting, the catalog is used instead, and images are downloaded from the
+locations it lists, such as an internal mirror. The public source is not
+contacted. The catalog stays in use until the next update without one.
+
+A catalog is a JSON file, with the driver name, and the Kubernetes version,
+image URL and SHA-256 checksum of each version image. Image URLs can be
+relative to the catalog. A catalog can be a URL or a local file path.
+
+The versions themselves are still decided by the driver. A catalog only
+changes where images of versions that the driver already knows are
+downloaded from, and cannot add versions. A catalog that lists versions
+unknown to the driver is rejected. On an air-gapped machine, the version
+list of the driver must already include the versions of the catalog.
+
+Examples:
+	kutti driver update vbox
+	kutti driver update vbox --catalog http://mirror.example.com/vbox/catalog.json
+	kutti setting set catalog-vbox /mnt/images/vbox/catalog.json
+`,
+				RunE:          driverUpdateCommand,
+				SilenceErrors: true,
 			},
+			SetFlagsFunc: SetCatalogFlag,
 		},
 		{
 			Cmd: &cobra.Command{
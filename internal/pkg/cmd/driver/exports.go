@@ -1,8 +1,12 @@
 package driver
 
 import (
+	"strings"
+
 	"github.com/kuttiproject/kuttilib"
+	"github.com/kuttiproject/kuttilog"
 
+	"github.com/kuttiproject/kutti/internal/pkg/catalog"
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
 
 	"github.com/spf13/cobra"
@@ -19,3 +23,84 @@ func DrivernameValidArgs(c *cobra.Command, args []string, toComplete string) ([]
 	possibilities := kuttilib.DriverNames()
 	return cli.StringCompletions(possibilities, toComplete)
 }
+
+// SetCatalogFlag adds the "--catalog" flag used by UpdateVersions to a
+// Cobra command.
+func SetCatalogFlag(c *cobra.Command) {
+	c.Flags().String("catalog", "", "URL or file path of a version catalog, overriding the catalog-DRIVERNAME setting")
+	c.MarkFlagFilename("catalog", "json")
+}
+
+// UpdateVersions updates the version list of a driver. If a catalog is
+// specified by the flag added by SetCatalogFlag, or by the
+// catalog-<drivername> setting, the catalog is loaded and saved instead,
+// and the public source of the driver is not contacted. Otherwise, any
+// saved catalog is removed, and the driver updates its version list.
+//
+// kuttilib has no way to add versions to the version list of a driver,
+// so a catalog only changes where the images of versions the driver
+// already knows are downloaded from. A catalog that lists other versions
+// is rejected.
+func UpdateVersions(c *cobra.Command, driver *kuttilib.Driver) error {
+	override, _ := c.Flags().GetString("catalog")
+	location := catalog.Location(driver.Name(), override)
+	if location == "" {
+		err := catalog.Remove(driver.Name())
+		if err != nil {
+			return err
+		}
+
+		return driver.UpdateVersionList()
+	}
+
+	kuttilog.Printf(kuttilog.Verbose, "Loading catalog %v...", location)
+	result, err := catalog.Load(location)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not load catalog: %v",
+			err,
+		)
+	}
+
+	known := map[string]bool{}
+	for _, versionname := range driver.VersionNames() {
+		known[versionname] = true
+	}
+	unknown := []string{}
+	for _, entry := range result.Versions {
+		if !known[entry.K8sVersion] {
+			unknown = append(unknown, entry.K8sVersion)
+		}
+	}
+	if len(unknown) > 0 {
+		return cli.WrapErrorMessagef(
+			1,
+			"catalog %v lists versions not known to driver '%v': %v. "+
+				"A catalog only changes where images of versions already known to the driver are downloaded from, "+
+				"and cannot add versions. Update the driver without a catalog first, or remove these versions from the catalog",
+			location,
+			driver.Name(),
+			strings.Join(unknown, ", "),
+		)
+	}
+
+	err = catalog.Save(driver.Name(), result)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not save catalog %v: %v",
+			location,
+			err,
+		)
+	}
+
+	kuttilog.Printf(
+		kuttilog.Info,
+		"Catalog %v lists %v versions.",
+		location,
+		len(result.Versions),
+	)
+
+	return nil
+}
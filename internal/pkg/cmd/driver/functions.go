@@ -71,7 +71,7 @@ func driverUpdateCommand(c *cobra.Command, args []string) error {
 	}
 
 	kuttilog.Println(kuttilog.Minimal, "Updating driver versions...")
-	err := driver.UpdateVersionList()
+	err := UpdateVersions(c, driver)
 	if err != nil {
 		return err
 	}
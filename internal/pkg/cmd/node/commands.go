@@ -1,7 +1,10 @@
 package node
 
 import (
+	"time"
+
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	clustercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/cluster"
 
 	"github.com/spf13/cobra"
 )
@@ -14,14 +17,29 @@ var nodeCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		{
 			Cmd: &cobra.Command{
-				Use:           "ls",
-				Aliases:       []string{"list"},
-				Args:          cobra.NoArgs,
-				Short:         "List nodes",
+				Use:     "ls",
+				Aliases: []string{"list"},
+				Args:    cobra.NoArgs,
+				Short:   "List nodes",
+				Long: `
+List nodes.
+
+With -o wide, the type and published ports of each node are also shown,
+and the IP address and SSH address of running nodes. Published ports are
+shown as HOSTPORT->NODEPORT.
+
+Examples:
+	kutti node ls
+	kutti node ls -o wide
+`,
 				RunE:          nodeLsCommand,
 				SilenceErrors: true,
 			},
-			SetFlagsFunc: SetClusterFlag,
+			SetFlagsFunc: func(c *cobra.Command) {
+				SetClusterFlag(c)
+
+				c.Flags().StringP("output", "o", "table", "output format (table, wide)")
+			},
 		},
 		{
 			Cmd: &cobra.Command{
@@ -52,9 +70,23 @@ var nodeCmd = &cli.Command{
 		},
 		{
 			Cmd: &cobra.Command{
-				Use:           "create NODENAME",
-				Aliases:       []string{"add"},
-				Short:         "Create a new node",
+				Use:     "create NODENAME",
+				Aliases: []string{"add"},
+				Short:   "Create a new node",
+				Long: `
+Create a new node.
+
+For drivers that use NAT networking, the SSH port of the node must be
+forwarded to a host port, specified with --sshport. With --sshport auto,
+a port that is free on the host and not used by any kutti cluster is
+chosen from the range in the port-range setting (default 10000-19999),
+and printed instead of the node name in quiet or minimal output.
+
+Examples:
+	kutti node create node1 --sshport 10001
+	kutti node create node1 --sshport auto
+	kutti setting set port-range 20000-20999
+`,
 				Args:          cobra.ExactArgs(1),
 				RunE:          nodeCreateCommand,
 				SilenceErrors: true,
@@ -62,7 +94,7 @@ var nodeCmd = &cli.Command{
 			SetFlagsFunc: func(c *cobra.Command) {
 				SetClusterFlag(c)
 
-				c.Flags().IntP("sshport", "p", 0, "host port to forward node SSH port")
+				c.Flags().StringP("sshport", "p", "", "host port to forward node SSH port, or auto")
 			},
 		},
 		{
@@ -95,21 +127,51 @@ var nodeCmd = &cli.Command{
 				c.Flags().BoolP("force", "f", false, "forcibly stop node (emergency use only)")
 			},
 		},
-		// {
-		// 	Cmd: &cobra.Command{
-		// 		Use:               "recover NODENAME",
-		// 		Short:             "Try to recover an unresponsive node",
-		// 		Args:              cobra.ExactValidArgs(1),
-		// 		ValidArgsFunction: NameValidArgs,
-		// 		RunE:              nodeRecoverCommand,
-		// 		SilenceErrors:     true,
-		// 	},
-		// 	SetFlagsFunc: SetClusterFlag,
-		// },
 		{
 			Cmd: &cobra.Command{
-				Use:               "publish NODENAME",
-				Short:             "Publish a node port to a host port",
+				Use:   "recover NODENAME",
+				Short: "Try to recover an unresponsive node",
+				Long: `
+Try to recover an unresponsive node.
+
+The node is force started, then force stopped, then started normally.
+After each step, the status of the VM is shown, and the node is checked
+for SSH reachability. Recovery stops as soon as the node can be reached
+after a start. If every attempt fails, the next manual step is suggested.
+
+Examples:
+	kutti node recover node1
+	kutti node recover node1 --max-attempts 3 --output json
+`,
+				Args:              cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+				ValidArgsFunction: NameValidArgs,
+				RunE:              nodeRecoverCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				SetClusterFlag(c)
+
+				c.Flags().Int("max-attempts", 1, "number of times to try the whole recovery sequence")
+				c.Flags().Duration("ssh-timeout", 2*time.Minute, "how long to wait for SSH after each start")
+				c.Flags().StringP("output", "o", "text", "output format (text, json)")
+				clustercmd.SetSSHFlags(c)
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "publish NODENAME",
+				Short: "Publish a node port to a host port",
+				Long: `
+Publish a node port to a host port.
+
+With --hostport auto, a port that is free on the host and not used by any
+kutti cluster is chosen from the range in the port-range setting (default
+10000-19999). The host port is printed in quiet or minimal output.
+
+Examples:
+	kutti node publish node1 --nodeport 80 --hostport 8080
+	kutti node publish node1 --nodeport 80 --hostport auto
+`,
 				Args:              cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
 				ValidArgsFunction: NameValidArgs,
 				RunE:              nodePublishCommand,
@@ -118,7 +180,7 @@ var nodeCmd = &cli.Command{
 			SetFlagsFunc: func(c *cobra.Command) {
 				SetClusterFlag(c)
 
-				c.Flags().IntP("hostport", "p", 0, "port on the host")
+				c.Flags().StringP("hostport", "p", "", "port on the host, or auto")
 				c.Flags().IntP("nodeport", "n", 0, "port on the node")
 
 				c.MarkFlagRequired("hostport")
@@ -154,19 +216,115 @@ var nodeCmd = &cli.Command{
 			SetFlagsFunc: func(c *cobra.Command) {
 				SetClusterFlag(c)
 
-				c.Flags().StringP("username", "u", "user1", "username for SSH connection")
-				c.Flags().StringP("password", "p", "Pass@word1", "password for SSH connection")
+				clustercmd.SetSSHFlags(c)
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "tunnel NODENAME",
+				Short: "Forward local ports to or through a node over SSH",
+				Long: `
+Forward local ports to or through a node over SSH.
+
+Unlike publish, this does not need port forwarding support from the driver.
+It uses the SSH connection of the node, like ssh -L and ssh -D.
+
+Each -L forward is written as [BINDADDRESS:]PORT:HOST:HOSTPORT. Connections
+to PORT on the host are connected to HOST:HOSTPORT from the node. HOST is
+resolved on the node, so localhost means the node itself.
+
+Each -D proxy is written as [BINDADDRESS:]PORT, and starts a SOCKS5 proxy
+on that port. Connections made through it are made from the node, so they
+can reach anything the node can, such as other nodes and cluster services.
+
+The default bind address is localhost. The tunnel stays open until Ctrl-C
+is pressed. With --background, it runs as a separate process instead. Its
+PID is written to a PID file, which is removed when the tunnel closes, and
+its output goes to a log file next to the PID file. Stop it by ending the
+process.
+
+Examples:
+	kutti node tunnel node1 -L 8080:localhost:80
+	kutti node tunnel node1 -L 6443:localhost:6443 -L 0.0.0.0:8443:10.96.0.1:443
+	kutti node tunnel node1 -D 1080
+	kutti node tunnel node1 -D 1080 --background
+	kill $(cat ~/.config/kutti/tunnels/mycluster-node1.pid)
+`,
+				Args:              cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+				ValidArgsFunction: NameValidArgs,
+				RunE:              nodeTunnelCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				SetClusterFlag(c)
+
+				c.Flags().StringArrayP("local-forward", "L", nil, "forward [BINDADDRESS:]PORT:HOST:HOSTPORT")
+				c.Flags().StringArrayP("socks", "D", nil, "start a SOCKS5 proxy on [BINDADDRESS:]PORT")
+				c.Flags().BoolP("background", "b", false, "run the tunnel as a background process")
+				c.Flags().String("pid-file", "", "PID file for a background tunnel (default in the kutti configuration directory)")
+				c.Flags().Bool("detached", false, "")
+				c.Flags().MarkHidden("detached")
+				clustercmd.SetSSHFlags(c)
 			},
 		},
 		{
 			Cmd: &cobra.Command{
-				Use:     "scp SOURCE TARGET",
+				Use:   "exec NODENAME -- COMMAND [ARGS...]",
+				Short: "Run a command on the node",
+				Long: `
+Run a command on the node.
+
+The command runs over SSH, without a terminal. Standard input is forwarded
+to it, and its standard output and error are streamed back. kutti exits
+with the exit status of the command.
+
+Examples:
+	kutti node exec node1 -- uname -a
+	kutti node exec node1 -- 'ls /etc | wc -l'
+	cat script.sh | kutti node exec node1 -- sh
+`,
+				Args:              cobra.MinimumNArgs(2),
+				ValidArgsFunction: NameValidArgs,
+				RunE:              nodeExecCommand,
+				SilenceErrors:     true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				SetClusterFlag(c)
+
+				clustercmd.SetSSHFlags(c)
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:     "scp SOURCE... TARGET",
 				Aliases: []string{"cp", "copy"},
-				Short:   "Copy a file to or from the node",
+				Short:   "Copy files to, from or between nodes",
 				Long: `
-Copy a file to or from the node.
+Copy files to, from or between nodes.
 			
 Either the source or the target must begin with a nodename followed by a colon.
+If both do, the file is copied between the nodes, through a temporary
+directory on the host.
+
+Several sources can be specified, in which case the target must be an
+existing directory. Wildcards in paths on nodes are expanded on the node;
+quote them so that they are not expanded by the local shell.
+
+With --all-nodes, the target is a colon followed by a path, and the sources
+are copied to that path on every node of the cluster.
+
+If some copies fail, the rest are still attempted, and the failures are
+listed at the end.
+
+Files are copied with the scp protocol, over an SSH connection that
+authenticates like node ssh. The scp command must be installed on the nodes.
+
+Progress is shown for each copy, estimated from the growth of the target.
+After each copy, the SHA-256 checksums of the copied files are compared on
+both sides, unless --verify=false is specified. With --resume, files that
+already exist on the target with the same size and checksum are skipped,
+so an interrupted copy can be repeated cheaply. Use the same target as the
+interrupted copy.
 
 Examples:
 	kutti node scp /some/file/on/host node1:/some/file
@@ -174,9 +332,17 @@ Examples:
 	
 	kutti node scp -r /some/directory/on/host node1:/some/directory
 	kutti node scp -r node1:/some/directory /some/directory/on/host
+
+	kutti node scp control:/some/file worker1:/some/file
+	kutti node scp -r control:/some/directory worker1:/some/directory
+
+	kutti node scp file1 file2 node1:/some/directory
+	kutti node scp 'node1:/var/log/*.log' ./logs
+	kutti node scp --all-nodes /some/file/on/host :/tmp
+	kutti node scp --resume images.tar node1:/tmp
  
 `,
-				Args:          cobra.ExactArgs(2),
+				Args:          cobra.MinimumNArgs(2),
 				RunE:          nodeSCPCommand,
 				SilenceErrors: true,
 			},
@@ -184,8 +350,10 @@ Examples:
 				SetClusterFlag(c)
 
 				c.Flags().BoolP("recurse", "r", false, "copy directories, recursively")
-				c.Flags().StringP("username", "u", "user1", "username for SSH connection")
-				c.Flags().StringP("password", "p", "Pass@word1", "password for SSH connection")
+				c.Flags().BoolP("all-nodes", "a", false, "copy to every node in the cluster. The target must be :PATH")
+				c.Flags().Bool("resume", false, "skip files whose size and checksum already match on the target")
+				c.Flags().Bool("verify", true, "compare checksums of copied files after each copy")
+				clustercmd.SetSSHFlags(c)
 			},
 		},
 	},
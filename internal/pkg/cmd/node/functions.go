@@ -3,7 +3,11 @@ package node
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kuttiproject/kuttilog"
@@ -12,11 +16,14 @@ import (
 
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
 	clustercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/cluster"
-	"github.com/kuttiproject/sshclient"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
 
 	"github.com/spf13/cobra"
 )
 
+// The value of a host port flag that asks for a free port to be chosen.
+const autoport = "auto"
+
 func getCluster(c *cobra.Command) (*kuttilib.Cluster, error) {
 	clustername, _ := c.Flags().GetString("cluster")
 
@@ -60,22 +67,114 @@ func nodeLsCommand(c *cobra.Command, args []string) error {
 		return nil
 	}
 
-	var nodelsFormatter = cli.NewTableRenderer(
-		"nodels",
-		[]*cli.TableColumn{
-			{Name: "Name", Width: 15, DefaultCheck: true},
-			//{Name: "IPAddress", Title: "IP Address", Width: 12},
-			{Name: "Status", Width: 15},
-			{Name: "CreatedAt", Title: "Created", Width: 15, FormatPrefix: "prettytime"},
-		},
-		"",
-	)
+	output, _ := c.Flags().GetString("output")
+	switch output {
+	case "table":
+		var nodelsFormatter = cli.NewTableRenderer(
+			"nodels",
+			[]*cli.TableColumn{
+				{Name: "Name", Width: 15, DefaultCheck: true},
+				{Name: "Status", Width: 15},
+				{Name: "CreatedAt", Title: "Created", Width: 15, FormatPrefix: "prettytime"},
+			},
+			"",
+		)
 
-	nodelsFormatter.Render(os.Stdout, cluster.Nodes())
+		nodelsFormatter.Render(os.Stdout, cluster.Nodes())
+	case "wide":
+		var nodelsWideFormatter = cli.NewTableRenderer(
+			"nodelswide",
+			[]*cli.TableColumn{
+				{Name: "Name", Width: 15, DefaultCheck: true},
+				{Name: "Type", Width: 10},
+				{Name: "Status", Width: 15},
+				{Name: "IPAddress", Title: "IP Address", Width: 15},
+				{Name: "SSHAddress", Title: "SSH Address", Width: 21},
+				{Name: "Ports", Width: 20},
+				{Name: "CreatedAt", Title: "Created", Width: 15, FormatPrefix: "prettytime"},
+			},
+			"",
+		)
+
+		nodelsWideFormatter.Render(os.Stdout, nodeWideViews(cluster))
+	default:
+		return cli.WrapErrorMessagef(
+			1,
+			"unknown output format '%v'. Use table or wide",
+			output,
+		)
+	}
 
 	return nil
 }
 
+// nodewideview is a row of node ls -o wide.
+type nodewideview struct {
+	Name       string
+	Type       string
+	Status     string
+	IPAddress  string
+	SSHAddress string
+	Ports      string
+	CreatedAt  time.Time
+}
+
+// nodeWideViews returns a row for each node of a cluster. Addresses come
+// from the driver, so they are only fetched for running nodes.
+func nodeWideViews(cluster *kuttilib.Cluster) map[string]*nodewideview {
+	result := map[string]*nodewideview{}
+	for nodename, node := range cluster.Nodes() {
+		status := node.Status()
+		view := &nodewideview{
+			Name:       nodename,
+			Type:       node.Type(),
+			Status:     string(status),
+			IPAddress:  "-",
+			SSHAddress: "-",
+			Ports:      formatPorts(node.Ports()),
+			CreatedAt:  node.CreatedAt(),
+		}
+
+		if status == kuttilib.NodeStatusRunning {
+			view.IPAddress = valueOrDash(node.IPAddress())
+			view.SSHAddress = valueOrDash(node.SSHAddress())
+		}
+
+		result[nodename] = view
+	}
+
+	return result
+}
+
+// formatPorts formats published ports as HOSTPORT->NODEPORT, in node port
+// order.
+func formatPorts(ports map[int]int) string {
+	if len(ports) == 0 {
+		return "-"
+	}
+
+	nodeports := make([]int, 0, len(ports))
+	for nodeport := range ports {
+		nodeports = append(nodeports, nodeport)
+	}
+	sort.Ints(nodeports)
+
+	result := make([]string, len(nodeports))
+	for i, nodeport := range nodeports {
+		result[i] = fmt.Sprintf("%v->%v", ports[nodeport], nodeport)
+	}
+
+	return strings.Join(result, ",")
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
 func nodeShowCommand(c *cobra.Command, args []string) error {
 	c.SilenceUsage = true
 
@@ -194,7 +293,31 @@ func nodeCreateCommand(cmd *cobra.Command, args []string) error {
 
 	// Check for sshport for drivers that require it
 	driver := cluster.Driver()
-	sshport, _ := cmd.Flags().GetInt("sshport")
+	sshportvalue, _ := cmd.Flags().GetString("sshport")
+	autosshport := sshportvalue == autoport
+	sshport := 0
+	if autosshport {
+		if driver.UsesNATNetworking() {
+			sshport, err = clustercmd.FindFreeHostPort(cluster)
+			if err != nil {
+				return cli.WrapErrorMessagef(
+					1,
+					"could not choose SSH port: %v",
+					err,
+				)
+			}
+			kuttilog.Printf(kuttilog.Verbose, "Chose host port %v for SSH.", sshport)
+		} else {
+			kuttilog.Printf(kuttilog.Verbose, "SSH port forwarding not needed for the '%v' driver.", driver.Name())
+			autosshport = false
+		}
+	} else if sshportvalue != "" {
+		sshport, err = parsePortFlag("sshport", sshportvalue)
+		if err != nil {
+			return err
+		}
+	}
+
 	if driver.UsesNATNetworking() && sshport == 0 {
 		return cli.WrapErrorMessagef(
 			1,
@@ -240,6 +363,11 @@ func nodeCreateCommand(cmd *cobra.Command, args []string) error {
 
 	if kuttilog.V(kuttilog.Info) {
 		kuttilog.Printf(kuttilog.Info, "Node '%s' created.", nodename)
+		if autosshport {
+			kuttilog.Printf(kuttilog.Info, "SSH port forwarded to host port %v.", sshport)
+		}
+	} else if autosshport {
+		kuttilog.Println(kuttilog.Quiet, sshport)
 	} else {
 		kuttilog.Println(kuttilog.Quiet, nodename)
 	}
@@ -247,6 +375,21 @@ func nodeCreateCommand(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// parsePortFlag parses the value of a host port flag that is not auto.
+func parsePortFlag(flagname string, value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || !kuttilib.ValidPort(port) {
+		return 0, cli.WrapErrorMessagef(
+			1,
+			"please provide a valid %v. Valid ports are between 1 and 65535, or %v",
+			flagname,
+			autoport,
+		)
+	}
+
+	return port, nil
+}
+
 func nodeStartCommand(c *cobra.Command, args []string) error {
 	c.SilenceUsage = true
 
@@ -307,77 +450,130 @@ func nodeStopCommand(c *cobra.Command, args []string) error {
 	return nil
 }
 
-// Triaged to a future release
-// func nodeRecoverCommand(c *cobra.Command, args []string) error {
-// 	c.SilenceUsage = true
-
-// 	cluster, err := getCluster(c)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	node, ok := cluster.GetNode(args[0])
-// 	if !ok {
-// 		return cli.WrapErrorMessagef(
-// 			2,
-// 			"node %v not found",
-// 			args[0],
-// 		)
-// 	}
-
-// 	// First, capture the status
-// 	status := node.Status()
-
-// 	// Then try to force start the node
-// 	err = node.ForceStart()
-
-// 	// If that works, stop
-// 	if err == nil {
-// 		// TODO: Message here
-// 		return nil
-// 	}
-// 	// Then try to force stop the node
-// 	err = node.ForceStop()
-
-// 	// If that does not work, stop with error
-// 	if err != nil {
-// 		// TODO: Better error message here
-// 		return cli.WrapError(1, err)
-// 	}
-
-// 	// If captured status was stopped, stop
-// 	if status == kuttilib.NodeStatusStopped {
-// 		// TODO: Message here
-// 		return nil
-// 	}
-// 	// Try to normal start the node
-// 	err = node.Start()
-
-// 	// If that works, stop
-// 	if err == nil {
-// 		// TODO: Message here
-// 		return nil
-// 	}
-// 	// Try to force start the node
-// 	err = node.ForceStart()
-
-// 	// If that works, stop
-// 	if err == nil {
-// 		// TODO: Message here
-// 		return nil
-// 	}
-
-// 	// Stop with error
-// 	return cli.WrapErrorMessagef(
-// 		1,
-// 		"could not recover node. You may have to delete and recreate it. The last error returned was: %v",
-// 		err,
-// 	)
-// }
+// Values used in node recovery reports.
+const (
+	recoverySSHOK          = "OK"
+	recoverySSHUnreachable = "Unreachable"
+	recoverySSHNotChecked  = "-"
 
-func nodePublishCommand(c *cobra.Command, args []string) error {
+	recoverysshinterval = 5 * time.Second
+)
+
+// recoverystep is one action of the node recovery sequence.
+type recoverystep struct {
+	name string
+	run  func() error
+	// starting is true for steps after which the node should become
+	// reachable over SSH.
+	starting bool
+}
+
+// recoveryattempt records the outcome of one recovery step.
+type recoveryattempt struct {
+	Attempt  int
+	Step     string
+	Error    string
+	VMStatus string
+	SSH      string
+	Duration string
+}
+
+// recoveryreport records the progress of a node recovery.
+type recoveryreport struct {
+	Node          string
+	InitialStatus string
+	Recovered     bool
+	Attempts      []*recoveryattempt
+	NextStep      string
+}
+
+// runRecovery runs the recovery steps in order, up to maxattempts times,
+// checking SSH reachability after each step. It stops as soon as the
+// node is reachable after a starting step. The sshcheck function should
+// wait for the node to become reachable if wait is true. Progress is
+// logged at the specified level.
+func runRecovery(
+	report *recoveryreport,
+	loglevel int,
+	steps []*recoverystep,
+	maxattempts int,
+	status func() string,
+	sshcheck func(wait bool) bool,
+) {
+	for attempt := 1; attempt <= maxattempts; attempt++ {
+		for _, step := range steps {
+			kuttilog.Printf(
+				loglevel,
+				"Attempt %v of %v: %v...",
+				attempt,
+				maxattempts,
+				step.name,
+			)
+
+			started := time.Now()
+			result := &recoveryattempt{
+				Attempt: attempt,
+				Step:    step.name,
+				SSH:     recoverySSHNotChecked,
+			}
+
+			err := step.run()
+			if err != nil {
+				result.Error = err.Error()
+				kuttilog.Printf(kuttilog.Verbose, "%v failed: %v.", step.name, err)
+			}
+
+			result.VMStatus = status()
+
+			// A failed start may still leave a working node behind, so
+			// reachability is checked regardless of errors.
+			reachable := sshcheck(step.starting)
+			if reachable {
+				result.SSH = recoverySSHOK
+			} else {
+				result.SSH = recoverySSHUnreachable
+			}
+
+			result.Duration = time.Since(started).Round(time.Second).String()
+			report.Attempts = append(report.Attempts, result)
+
+			kuttilog.Printf(
+				loglevel,
+				"VM status: %v, SSH: %v.",
+				result.VMStatus,
+				result.SSH,
+			)
+
+			if reachable && step.starting {
+				report.Recovered = true
+				return
+			}
+		}
+	}
+}
+
+func nodeRecoverCommand(c *cobra.Command, args []string) error {
 	c.SilenceUsage = true
 
+	output, _ := c.Flags().GetString("output")
+	if output != "text" && output != "json" {
+		return cli.WrapErrorMessagef(
+			1,
+			"unknown output format '%v'. Use text or json",
+			output,
+		)
+	}
+
+	maxattempts, _ := c.Flags().GetInt("max-attempts")
+	if maxattempts < 1 {
+		return cli.WrapErrorMessage(
+			1,
+			"--max-attempts must be at least 1",
+		)
+	}
+
+	sshtimeout, _ := c.Flags().GetDuration("ssh-timeout")
+
 	cluster, err := getCluster(c)
 	if err != nil {
 		return err
@@ -393,32 +589,152 @@ func nodePublishCommand(c *cobra.Command, args []string) error {
 		)
 	}
 
-	nodeport, _ := c.Flags().GetInt("nodeport")
-	if !kuttilib.ValidPort(nodeport) {
-		return cli.WrapErrorMessage(
-			1,
-			"please provide a valid nodeport. Valid ports are between 1 and 65535",
+	client, err := clustercmd.NewSSHClient(c, cluster)
+	if err != nil {
+		return err
+	}
+
+	// First, capture the status
+	report := &recoveryreport{
+		Node:          nodename,
+		InitialStatus: string(node.Status()),
+		Attempts:      []*recoveryattempt{},
+	}
+	// Progress would corrupt JSON output at the default level
+	loglevel := kuttilog.Info
+	if output == "json" {
+		loglevel = kuttilog.Verbose
+	}
+	kuttilog.Printf(loglevel, "Recovering node %v. Current status: %v.", nodename, report.InitialStatus)
+
+	steps := []*recoverystep{
+		{name: "Force start", run: node.ForceStart, starting: true},
+		{name: "Force stop", run: node.ForceStop},
+		{name: "Start", run: node.Start, starting: true},
+	}
+
+	sshcheck := func(wait bool) bool {
+		deadline := time.Now().Add(sshtimeout)
+		for {
+			address := node.SSHAddress()
+			if address != "" {
+				exitstatus, err := client.Run(address, "true", nil, nil, nil)
+				if err == nil && exitstatus == 0 {
+					return true
+				}
+			}
+
+			if !wait || time.Now().After(deadline) {
+				return false
+			}
+
+			time.Sleep(recoverysshinterval)
+		}
+	}
+
+	runRecovery(
+		report,
+		loglevel,
+		steps,
+		maxattempts,
+		func() string { return string(node.Status()) },
+		sshcheck,
+	)
+
+	if !report.Recovered {
+		report.NextStep = fmt.Sprintf(
+			"Check the VM of node '%[1]v' using the management tools of %[2]v, "+
+				"and stop or repair it there. If that does not work, remove and recreate the node using "+
+				"'kutti node rm %[1]v --force' and 'kutti node create %[1]v'.",
+			nodename,
+			cluster.Driver().Description(),
 		)
 	}
 
-	hostport, _ := c.Flags().GetInt("hostport")
-	if !kuttilib.ValidPort(hostport) {
-		return cli.WrapErrorMessage(
+	if output == "json" {
+		renderer := cli.NewJSONRenderer(2)
+		renderer.Render(os.Stdout, report)
+	}
+
+	if !report.Recovered {
+		if output != "json" {
+			kuttilog.Printf(kuttilog.Quiet, "Next step: %v", report.NextStep)
+		}
+
+		return cli.WrapErrorMessagef(
 			1,
-			"please provide a valid hostport. Valid ports are between 1 and 65535",
+			"could not recover node '%v' in %v attempts",
+			nodename,
+			maxattempts,
 		)
 	}
 
-	err = cluster.CheckHostPort(hostport)
+	if output != "json" {
+		if kuttilog.V(kuttilog.Info) {
+			kuttilog.Printf(kuttilog.Info, "Node '%s' recovered.", nodename)
+		} else {
+			kuttilog.Println(kuttilog.Minimal, nodename)
+		}
+	}
+
+	return nil
+}
+
+func nodePublishCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	cluster, err := getCluster(c)
 	if err != nil {
+		return err
+	}
+
+	nodename := args[0]
+	node, ok := cluster.GetNode(nodename)
+	if !ok {
 		return cli.WrapErrorMessagef(
+			2,
+			"node '%v' not found",
+			nodename,
+		)
+	}
+
+	nodeport, _ := c.Flags().GetInt("nodeport")
+	if !kuttilib.ValidPort(nodeport) {
+		return cli.WrapErrorMessage(
 			1,
-			"cannot forward to host port %v: %v",
-			hostport,
-			err,
+			"please provide a valid nodeport. Valid ports are between 1 and 65535",
 		)
 	}
 
+	hostportvalue, _ := c.Flags().GetString("hostport")
+	var hostport int
+	if hostportvalue == autoport {
+		hostport, err = clustercmd.FindFreeHostPort(cluster)
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"could not choose host port: %v",
+				err,
+			)
+		}
+		kuttilog.Printf(kuttilog.Verbose, "Chose host port %v.", hostport)
+	} else {
+		hostport, err = parsePortFlag("hostport", hostportvalue)
+		if err != nil {
+			return err
+		}
+
+		err = cluster.CheckHostPort(hostport)
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"cannot forward to host port %v: %v",
+				hostport,
+				err,
+			)
+		}
+	}
+
 	err = node.ForwardPort(hostport, nodeport)
 	if err != nil {
 		return cli.WrapErrorMessagef(
@@ -527,21 +843,22 @@ func nodeSSHCommand(c *cobra.Command, args []string) error {
 		)
 	}
 
-	username, _ := c.Flags().GetString("username")
-	if username == "" {
-		username = "user1"
-	}
-
-	password, _ := c.Flags().GetString("password")
-	if password == "" {
-		password = "Pass@word1"
+	client, err := clustercmd.NewSSHClient(c, cluster)
+	if err != nil {
+		return err
 	}
 
 	kuttilog.Printf(kuttilog.Info, "Connecting to node %s...", nodename)
 
-	client := sshclient.NewWithPassword(username, password)
-
-	client.RunInteractiveShell(address)
+	err = client.RunInteractiveShell(address)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not connect to node '%v': %v",
+			nodename,
+			err,
+		)
+	}
 
 	return nil
 }
@@ -655,122 +972,465 @@ func getNodeSSHAddress(cluster *kuttilib.Cluster, nodename string) (string, erro
 	return address, nil
 }
 
-func nodeSCPCommand(c *cobra.Command, args []string) error {
-	c.SilenceUsage = true
+func (a *cparg) String() string {
+	if a.hasnodename {
+		return a.nodename + ":" + a.filepath
+	}
 
-	cluster, err := getCluster(c)
-	if err != nil {
-		return err
+	return a.filepath
+}
+
+// getSCPTargets returns the targets of a copy. With the --all-nodes flag,
+// the target argument is a colon followed by a path, which is used on
+// every node of the cluster.
+func getSCPTargets(cluster *kuttilib.Cluster, arg string, allnodes bool) ([]*cparg, error) {
+	if allnodes {
+		if !strings.HasPrefix(arg, ":") {
+			return nil, cli.WrapErrorMessagef(
+				1,
+				"with --all-nodes, the target must be a colon followed by a path, like ':/tmp'. Got '%v'",
+				arg,
+			)
+		}
+
+		targetpath := arg[1:]
+		if targetpath == "" {
+			targetpath = "."
+		}
+
+		nodenames := cluster.NodeNames()
+		if len(nodenames) == 0 {
+			return nil, cli.WrapErrorMessagef(
+				1,
+				"cluster '%v' has no nodes",
+				cluster.Name(),
+			)
+		}
+		sort.Strings(nodenames)
+
+		targets := make([]*cparg, 0, len(nodenames))
+		for _, nodename := range nodenames {
+			targets = append(targets, &cparg{
+				nodename:    nodename,
+				filepath:    targetpath,
+				hasnodename: true,
+			})
+		}
+
+		return targets, nil
 	}
 
-	// Parse the arguments
-	arg1, err := parseCPArg(args[0])
-	if err != nil {
-		return err
+	if strings.HasPrefix(arg, ":") {
+		return nil, cli.WrapErrorMessagef(
+			1,
+			"'%v' has no node name. Use --all-nodes to copy to every node",
+			arg,
+		)
 	}
 
-	arg2, err := parseCPArg(args[1])
+	target, err := parseCPArg(arg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// If neither the first argument, nor the second
-	// have a nodename, the user should be using the
-	// cp or copy command instead.
-	if !(arg1.hasnodename || arg2.hasnodename) {
-		return cli.WrapErrorMessage(
-			1,
-			"must specify at least one node",
-		)
+	// If the target has a nodename but not a path, we
+	// should assume the current directory on the node.
+	if target.hasnodename && target.filepath == "" {
+		target.filepath = "."
+	}
 
+	return []*cparg{target}, nil
+}
+
+// expandCPArg expands wildcards in a source argument. Wildcards in paths
+// on nodes are expanded on the node.
+func expandCPArg(client *remote.Client, cluster *kuttilib.Cluster, source *cparg) ([]*cparg, error) {
+	if source.hasnodename {
+		if !remote.HasGlob(source.filepath) {
+			return []*cparg{source}, nil
+		}
+
+		address, err := getNodeSSHAddress(cluster, source.nodename)
+		if err != nil {
+			return nil, err
+		}
+
+		paths, err := client.Glob(address, source.filepath)
+		if err != nil {
+			return nil, cli.WrapError(2, err)
+		}
+
+		results := make([]*cparg, 0, len(paths))
+		for _, matchpath := range paths {
+			results = append(results, &cparg{
+				nodename:    source.nodename,
+				filepath:    matchpath,
+				hasnodename: true,
+			})
+		}
+
+		return results, nil
 	}
 
-	// If both arguments have a nodename, it is an
-	// error.
-	if arg1.hasnodename && arg2.hasnodename {
-		return cli.WrapErrorMessage(
-			1,
-			"copying between nodes is not supported",
-		)
+	if source.localfileexists {
+		return []*cparg{source}, nil
 	}
 
-	// If the first (source) argument does not have
-	// a nodename, then the file or directory
-	// specified must exist on the host.
-	if (!arg1.hasnodename) && (!arg1.localfileexists) {
-		return cli.WrapErrorMessagef(
+	// Shells on Windows do not expand wildcards
+	var paths []string
+	if remote.HasGlob(source.filepath) {
+		paths, _ = filepath.Glob(source.filepath)
+	}
+
+	if len(paths) == 0 {
+		return nil, cli.WrapErrorMessagef(
 			2,
 			"'%v': no such file or directory",
-			arg1.filepath,
+			source.filepath,
 		)
 	}
 
-	// If the second (destination) argument has a
-	// nodename but not a path, we should assume
-	// the current directoy on the node.
-	if arg2.hasnodename && arg2.filepath == "" {
-		arg2.filepath = "."
+	results := make([]*cparg, 0, len(paths))
+	for _, matchpath := range paths {
+		fi, err := os.Stat(matchpath)
+		if err != nil {
+			return nil, cli.WrapError(1, err)
+		}
+
+		results = append(results, &cparg{
+			filepath:         matchpath,
+			localfileexists:  true,
+			localisdirectory: fi.IsDir(),
+		})
 	}
 
-	recurseFlag, _ := c.Flags().GetBool("recurse")
+	return results, nil
+}
+
+// checkSCPTargetIsDir returns an error if a target of a copy with
+// several sources is not an existing directory.
+func checkSCPTargetIsDir(client *remote.Client, cluster *kuttilib.Cluster, target *cparg) error {
+	isdir := target.localisdirectory
+	if target.hasnodename {
+		address, err := getNodeSSHAddress(cluster, target.nodename)
+		if err != nil {
+			return err
+		}
 
-	// If the first (source) argument does not have
-	// a nodename, and is a directory, the --recurse
-	// flag should be specified.
-	if (!arg1.hasnodename) &&
-		arg1.localisdirectory &&
-		(!recurseFlag) {
+		isdir, err = client.IsDir(address, target.filepath)
+		if err != nil {
+			return err
+		}
+	}
 
+	if !isdir {
 		return cli.WrapErrorMessagef(
 			1,
-			"'%v' is a directory. Use the --recurse option.",
-			arg1.filepath,
+			"target '%v' is not a directory",
+			target,
 		)
 	}
 
-	username, _ := c.Flags().GetString("username")
-	if username == "" {
-		username = "user1"
+	return nil
+}
+
+// copyProgress returns a function that shows the progress of a copy,
+// in the same style as version pull.
+func copyProgress() func(current int64, total int64) {
+	started := time.Now()
+	prevMib := int64(-1)
+	done := false
+
+	return func(current int64, total int64) {
+		if done {
+			return
+		}
+
+		currentMib := current / 1048576
+		if currentMib > prevMib || current == total {
+			elapsed := time.Since(started).Seconds()
+			rate := float64(0)
+			if elapsed > 0 {
+				rate = float64(current) / elapsed
+			}
+
+			eta := "-"
+			if rate > 0 {
+				eta = time.Duration(float64(total-current) / rate * float64(time.Second)).Round(time.Second).String()
+			}
+
+			fmt.Printf(
+				"\r    Copied %v/%v MiB, %.1f MiB/s, ETA %v    ",
+				currentMib,
+				total/1048576,
+				rate/1048576,
+				eta,
+			)
+			prevMib = currentMib
+		}
+
+		if current == total {
+			fmt.Println()
+			done = true
+		}
 	}
+}
 
-	password, _ := c.Flags().GetString("password")
-	if password == "" {
-		password = "Pass@word1"
+// copyFile copies one file or directory between the host and a node, or
+// between nodes.
+func copyFile(client *remote.Client, cluster *kuttilib.Cluster, source *cparg, target *cparg, copyoptions remote.CopyOptions) error {
+	// If the source does not have a nodename, and is a
+	// directory, the --recurse flag should be specified.
+	if (!source.hasnodename) && source.localisdirectory && (!copyoptions.Recurse) {
+		return cli.WrapErrorMessagef(
+			1,
+			"'%v' is a directory. Use the --recurse option.",
+			source.filepath,
+		)
 	}
 
-	// If the first (source) argument has a nodename, this is a
-	// copy from node operation.
-	if arg1.hasnodename {
+	// With several sources, some may be on the host, like
+	// the target.
+	if !(source.hasnodename || target.hasnodename) {
+		return cli.WrapErrorMessagef(
+			1,
+			"'%v' and '%v' are both on the host",
+			source,
+			target,
+		)
+	}
+
+	kuttilog.Printf(kuttilog.Info, "Copying %v to %v...", source, target)
+	if kuttilog.V(kuttilog.Info) {
+		copyoptions.Progress = copyProgress()
+	}
 
-		address, err := getNodeSSHAddress(cluster, arg1.nodename)
+	// If both arguments have a nodename, this is a copy
+	// between nodes, streamed through the host.
+	if source.hasnodename && target.hasnodename {
+		sourceaddress, err := getNodeSSHAddress(cluster, source.nodename)
 		if err != nil {
 			return err
 		}
 
-		kuttilog.Printf(kuttilog.Info, "Copying from node %s...", arg1.nodename)
-
-		client := sshclient.NewWithPassword(username, password)
+		targetaddress, err := getNodeSSHAddress(cluster, target.nodename)
+		if err != nil {
+			return err
+		}
 
-		err = client.CopyFrom(address, arg1.filepath, arg2.filepath, recurseFlag)
+		return client.CopyBetween(sourceaddress, source.filepath, targetaddress, target.filepath, &copyoptions)
+	}
 
+	// If the source has a nodename, this is a copy from node
+	// operation.
+	if source.hasnodename {
+		address, err := getNodeSSHAddress(cluster, source.nodename)
 		if err != nil {
 			return err
 		}
-	} else {
-		address, err := getNodeSSHAddress(cluster, arg2.nodename)
+
+		return client.CopyFrom(address, source.filepath, target.filepath, &copyoptions)
+	}
+
+	address, err := getNodeSSHAddress(cluster, target.nodename)
+	if err != nil {
+		return err
+	}
+
+	return client.CopyTo(address, source.filepath, target.filepath, &copyoptions)
+}
+
+// copyresult is the outcome of one copy, or of a failure that prevented
+// copies, by node scp.
+type copyresult struct {
+	Source string
+	Target string
+	Error  string
+	err    error
+}
+
+func nodeSCPCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	cluster, err := getCluster(c)
+	if err != nil {
+		return err
+	}
+
+	recurseFlag, _ := c.Flags().GetBool("recurse")
+	resumeFlag, _ := c.Flags().GetBool("resume")
+	verifyFlag, _ := c.Flags().GetBool("verify")
+	copyoptions := remote.CopyOptions{
+		Recurse: recurseFlag,
+		Resume:  resumeFlag,
+		Verify:  verifyFlag,
+	}
+	allnodesFlag, _ := c.Flags().GetBool("all-nodes")
+
+	// Parse the arguments. The last one is the target.
+	targets, err := getSCPTargets(cluster, args[len(args)-1], allnodesFlag)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]*cparg, 0, len(args)-1)
+	hasnodename := targets[0].hasnodename
+	for _, arg := range args[:len(args)-1] {
+		source, err := parseCPArg(arg)
 		if err != nil {
 			return err
 		}
 
-		kuttilog.Printf(kuttilog.Info, "Copying to node %s...", arg2.nodename)
+		sources = append(sources, source)
+		hasnodename = hasnodename || source.hasnodename
+	}
 
-		client := sshclient.NewWithPassword(username, password)
+	// If no argument has a nodename, the user should be
+	// using the cp or copy command instead.
+	if !hasnodename {
+		return cli.WrapErrorMessage(
+			1,
+			"must specify at least one node",
+		)
+	}
 
-		err = client.CopyTo(address, arg1.filepath, arg2.filepath, recurseFlag)
+	client, err := clustercmd.NewSSHClient(c, cluster)
+	if err != nil {
+		return err
+	}
+
+	// Failures are recorded per source and per target, and
+	// do not stop the remaining copies.
+	results := []*copyresult{}
+	record := func(source string, target string, err error) {
+		result := &copyresult{Source: source, Target: target, err: err}
 		if err != nil {
-			return err
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	expanded := []*cparg{}
+	for _, source := range sources {
+		matches, err := expandCPArg(client, cluster, source)
+		if err != nil {
+			record(source.String(), "", err)
+			continue
+		}
+
+		expanded = append(expanded, matches...)
+	}
+
+	for _, target := range targets {
+		// Several sources can only be copied into a directory
+		if len(sources) > 1 || len(expanded) > 1 {
+			err = checkSCPTargetIsDir(client, cluster, target)
+			if err != nil {
+				record("", target.String(), err)
+				continue
+			}
+		}
+
+		for _, source := range expanded {
+			err = copyFile(client, cluster, source, target, copyoptions)
+			record(source.String(), target.String(), err)
 		}
 	}
 
+	return reportCopyResults(results)
+}
+
+// reportCopyResults returns the error of a single copy as is. For several
+// copies, it shows a table of failures, and returns an error if there
+// were any.
+func reportCopyResults(results []*copyresult) error {
+	if len(results) == 1 {
+		return results[0].err
+	}
+
+	failures := []*copyresult{}
+	for _, result := range results {
+		if result.err != nil {
+			failures = append(failures, result)
+		}
+	}
+
+	if len(failures) == 0 {
+		kuttilog.Printf(kuttilog.Info, "%v copies completed.", len(results))
+		return nil
+	}
+
+	var failuresFormatter = cli.NewTableRenderer(
+		"scpfailures",
+		[]*cli.TableColumn{
+			{Name: "Source", Width: 30},
+			{Name: "Target", Width: 30},
+			{Name: "Error", Width: 50},
+		},
+		"",
+	)
+	failuresFormatter.Render(os.Stdout, failures)
+
+	return cli.WrapErrorMessagef(
+		1,
+		"%v of %v copies failed",
+		len(failures),
+		len(results),
+	)
+}
+
+func nodeExecCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	// Everything after the node name is the remote command
+	dashat := c.ArgsLenAtDash()
+	if dashat != -1 && dashat != 1 {
+		return cli.WrapErrorMessage(
+			1,
+			"expected a single node name before --",
+		)
+	}
+
+	cluster, err := getCluster(c)
+	if err != nil {
+		return err
+	}
+
+	nodename := args[0]
+	address, err := getNodeSSHAddress(cluster, nodename)
+	if err != nil {
+		return err
+	}
+
+	client, err := clustercmd.NewSSHClient(c, cluster)
+	if err != nil {
+		return err
+	}
+
+	// Like ssh, join the arguments with spaces, and let the
+	// remote shell interpret them.
+	command := strings.Join(args[1:], " ")
+
+	kuttilog.Printf(kuttilog.Verbose, "Running on node %s: %s", nodename, command)
+
+	exitstatus, err := client.Run(address, command, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not run command on node '%v': %v",
+			nodename,
+			err,
+		)
+	}
+
+	if exitstatus != 0 {
+		return cli.WrapErrorMessagef(
+			exitstatus,
+			"command exited with status %v on node '%v'",
+			exitstatus,
+			nodename,
+		)
+	}
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package node
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -160,3 +161,102 @@ func TestCPArg(t *testing.T) {
 	}
 
 }
+
+func TestRunRecovery(t *testing.T) {
+	failure := errors.New("VM hung")
+
+	testCases := []struct {
+		name        string
+		maxattempts int
+		// reachable lists the results of successive SSH checks
+		reachable  []bool
+		forcestart error
+		recovered  bool
+		steps      int
+	}{
+		{
+			name:        "force start works",
+			maxattempts: 1,
+			reachable:   []bool{true},
+			recovered:   true,
+			steps:       1,
+		},
+		{
+			name:        "normal start works",
+			maxattempts: 1,
+			reachable:   []bool{false, false, true},
+			forcestart:  failure,
+			recovered:   true,
+			steps:       3,
+		},
+		{
+			name:        "reachable after stop is not recovered",
+			maxattempts: 1,
+			reachable:   []bool{false, true, false},
+			recovered:   false,
+			steps:       3,
+		},
+		{
+			name:        "second attempt works",
+			maxattempts: 2,
+			reachable:   []bool{false, false, false, true},
+			recovered:   true,
+			steps:       4,
+		},
+		{
+			name:        "all attempts fail",
+			maxattempts: 2,
+			reachable:   []bool{},
+			forcestart:  failure,
+			recovered:   false,
+			steps:       6,
+		},
+	}
+
+	for _, tc := range testCases {
+		checks := 0
+		sshcheck := func(wait bool) bool {
+			defer func() { checks++ }()
+			return checks < len(tc.reachable) && tc.reachable[checks]
+		}
+
+		steps := []*recoverystep{
+			{name: "Force start", run: func() error { return tc.forcestart }, starting: true},
+			{name: "Force stop", run: func() error { return nil }},
+			{name: "Start", run: func() error { return nil }, starting: true},
+		}
+
+		report := &recoveryreport{}
+		runRecovery(report, 0, steps, tc.maxattempts, func() string { return "Running" }, sshcheck)
+
+		if report.Recovered != tc.recovered {
+			t.Fatalf("case '%v': expected recovered to be %v", tc.name, tc.recovered)
+		}
+
+		if len(report.Attempts) != tc.steps {
+			t.Fatalf("case '%v': expected %v steps, got %v", tc.name, tc.steps, len(report.Attempts))
+		}
+
+		if tc.forcestart != nil && report.Attempts[0].Error != tc.forcestart.Error() {
+			t.Fatalf("case '%v': expected error to be recorded, got '%v'", tc.name, report.Attempts[0].Error)
+		}
+	}
+}
+
+func TestFormatPorts(t *testing.T) {
+	testCases := []struct {
+		ports    map[int]int
+		expected string
+	}{
+		{ports: nil, expected: "-"},
+		{ports: map[int]int{22: 10001}, expected: "10001->22"},
+		{ports: map[int]int{6443: 16443, 22: 10001, 80: 8080}, expected: "10001->22,8080->80,16443->6443"},
+	}
+
+	for _, tc := range testCases {
+		result := formatPorts(tc.ports)
+		if result != tc.expected {
+			t.Fatalf("ports %v: expected '%v', got '%v'", tc.ports, tc.expected, result)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package node
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kuttiproject/kuttilib"
+	"github.com/kuttiproject/kuttilog"
+	"github.com/kuttiproject/workspace"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	clustercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/cluster"
+	"github.com/kuttiproject/kutti/internal/pkg/remote"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	tunnelsdirname = "tunnels"
+	// How long a background tunnel gets to start listening
+	tunnelstarttimeout = 30 * time.Second
+)
+
+// tunnelPIDFile returns the default PID file of a background tunnel to
+// a node.
+func tunnelPIDFile(clustername string, nodename string) (string, error) {
+	tunnelsdir, err := workspace.Configsubdir(tunnelsdirname)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(tunnelsdir, clustername+"-"+nodename+".pid"), nil
+}
+
+func nodeTunnelCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	forwardspecs, _ := c.Flags().GetStringArray("local-forward")
+	proxyspecs, _ := c.Flags().GetStringArray("socks")
+	if len(forwardspecs) == 0 && len(proxyspecs) == 0 {
+		return cli.WrapErrorMessage(
+			1,
+			"specify at least one forward with -L or SOCKS proxy with -D",
+		)
+	}
+
+	forwards := make([]*remote.Forward, 0, len(forwardspecs))
+	for _, spec := range forwardspecs {
+		forward, err := remote.ParseForward(spec)
+		if err != nil {
+			return cli.WrapError(1, err)
+		}
+		forwards = append(forwards, forward)
+	}
+
+	proxies := make([]string, 0, len(proxyspecs))
+	for _, spec := range proxyspecs {
+		proxy, err := remote.ParseDynamicForward(spec)
+		if err != nil {
+			return cli.WrapError(1, err)
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	cluster, err := getCluster(c)
+	if err != nil {
+		return err
+	}
+
+	nodename := args[0]
+	address, err := getNodeSSHAddress(cluster, nodename)
+	if err != nil {
+		return err
+	}
+
+	pidfile, _ := c.Flags().GetString("pid-file")
+	if pidfile == "" {
+		pidfile, err = tunnelPIDFile(cluster.Name(), nodename)
+		if err != nil {
+			return cli.WrapError(1, err)
+		}
+	}
+	pidfile, err = filepath.Abs(pidfile)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	background, _ := c.Flags().GetBool("background")
+	if background {
+		return startBackgroundTunnel(nodename, pidfile)
+	}
+
+	client, err := clustercmd.NewSSHClient(c, cluster)
+	if err != nil {
+		return err
+	}
+
+	tunnel, err := client.OpenTunnel(address, forwards, proxies)
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not open tunnel to node '%v': %v",
+			nodename,
+			err,
+		)
+	}
+	defer tunnel.Close()
+
+	// A detached tunnel announces that it is listening by writing its
+	// PID file, and removes the file when it stops.
+	detached, _ := c.Flags().GetBool("detached")
+	if detached {
+		err = os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"could not write PID file: %v",
+				err,
+			)
+		}
+		defer os.Remove(pidfile)
+	}
+
+	reportTunnel(cluster, nodename, forwards, tunnel.Addresses())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		<-stop
+		kuttilog.Println(kuttilog.Info, "Closing tunnel.")
+		tunnel.Close()
+	}()
+
+	err = tunnel.Wait()
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"tunnel to node '%v' closed: %v",
+			nodename,
+			err,
+		)
+	}
+
+	return nil
+}
+
+func reportTunnel(cluster *kuttilib.Cluster, nodename string, forwards []*remote.Forward, addresses []string) {
+	if !kuttilog.V(kuttilog.Info) {
+		for _, address := range addresses {
+			kuttilog.Println(kuttilog.Minimal, address)
+		}
+		return
+	}
+
+	for i, address := range addresses {
+		if i < len(forwards) {
+			kuttilog.Printf(
+				kuttilog.Info,
+				"Forwarding %v to %v on node %v.",
+				address,
+				forwards[i].RemoteAddress,
+				nodename,
+			)
+		} else {
+			kuttilog.Printf(
+				kuttilog.Info,
+				"SOCKS5 proxy listening on %v, connecting from node %v.",
+				address,
+				nodename,
+			)
+		}
+	}
+	kuttilog.Println(kuttilog.Info, "Press Ctrl-C to close the tunnel.")
+}
+
+// startBackgroundTunnel runs this command again as a detached process,
+// and waits until it is listening. The output of the detached process
+// goes to a log file next to the PID file.
+func startBackgroundTunnel(nodename string, pidfile string) error {
+	_, err := os.Stat(pidfile)
+	if err == nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"a tunnel to node '%v' seems to be running already. If it is not, remove %v",
+			nodename,
+			pidfile,
+		)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(pidfile), 0755)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+
+	logfile := strings.TrimSuffix(pidfile, filepath.Ext(pidfile)) + ".log"
+	log, err := os.Create(logfile)
+	if err != nil {
+		return cli.WrapError(1, err)
+	}
+	defer log.Close()
+
+	// Later occurrences of a flag override earlier ones.
+	childargs := append(
+		os.Args[1:],
+		"--background=false",
+		"--detached",
+		"--pid-file", pidfile,
+	)
+
+	child := exec.Command(executable, childargs...)
+	child.Stdout = log
+	child.Stderr = log
+	child.SysProcAttr = detachedProcAttr()
+
+	err = child.Start()
+	if err != nil {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not start background tunnel: %v",
+			err,
+		)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		child.Wait()
+		close(exited)
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(tunnelstarttimeout)
+	for {
+		select {
+		case <-exited:
+			output, _ := os.ReadFile(logfile)
+			return cli.WrapErrorMessagef(
+				1,
+				"background tunnel failed: %v",
+				strings.TrimSpace(string(output)),
+			)
+		case <-timeout:
+			child.Process.Kill()
+			return cli.WrapErrorMessagef(
+				1,
+				"background tunnel did not start in %v. See %v",
+				tunnelstarttimeout,
+				logfile,
+			)
+		case <-ticker.C:
+			_, err := os.Stat(pidfile)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			if kuttilog.V(kuttilog.Info) {
+				kuttilog.Printf(
+					kuttilog.Info,
+					"Tunnel to node %v running in the background with PID %v.",
+					nodename,
+					child.Process.Pid,
+				)
+				kuttilog.Printf(kuttilog.Info, "PID file: %v", pidfile)
+				kuttilog.Printf(kuttilog.Info, "Log file: %v", logfile)
+			} else {
+				kuttilog.Println(kuttilog.Minimal, child.Process.Pid)
+			}
+
+			return nil
+		}
+	}
+}
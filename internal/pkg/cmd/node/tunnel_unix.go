@@ -0,0 +1,11 @@
+//go:build !windows
+
+package node
+
+import "syscall"
+
+// detachedProcAttr starts a process in a new session, so that it
+// survives the terminal it was started from.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package node
+
+import "syscall"
+
+// DETACHED_PROCESS is not defined by the syscall package.
+const detachedProcess = 0x00000008
+
+// detachedProcAttr starts a process without a console, so that it
+// survives the console it was started from.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess,
+		HideWindow:    true,
+	}
+}
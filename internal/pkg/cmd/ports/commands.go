@@ -0,0 +1,35 @@
+package ports
+
+import (
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+
+	"github.com/spf13/cobra"
+)
+
+var portscommand = &cli.Command{
+	Cmd: &cobra.Command{
+		Use:   "ports",
+		Short: "List host ports used by all clusters",
+		Long: `
+List host ports used by all clusters.
+
+Every host port forwarded to a node is listed with its cluster, node, node
+port and purpose, including the SSH ports of nodes. A host port claimed by
+more than one node is a conflict: only one of the nodes can use it at a
+time, even if the others are stopped. Conflicts are marked, and with
+--conflicts only they are listed.
+
+Examples:
+	kutti ports
+	kutti ports --conflicts
+	kutti ports -o json
+`,
+		Args:          cobra.NoArgs,
+		RunE:          portsCommand,
+		SilenceErrors: true,
+	},
+	SetFlagsFunc: func(c *cobra.Command) {
+		c.Flags().StringP("output", "o", "table", "output format (table, json)")
+		c.Flags().Bool("conflicts", false, "list only host ports claimed by more than one node")
+	},
+}
@@ -0,0 +1,10 @@
+package ports
+
+import (
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+)
+
+// CommandTree returns the top level ports command
+func CommandTree() *cli.Command {
+	return portscommand
+}
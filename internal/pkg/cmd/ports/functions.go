@@ -0,0 +1,151 @@
+package ports
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kuttiproject/kuttilog"
+
+	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	clustercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/cluster"
+
+	"github.com/spf13/cobra"
+)
+
+// portview is a host port claimed by a node.
+type portview struct {
+	HostPort int
+	Cluster  string
+	Node     string
+	NodePort int
+	Purpose  string
+	Conflict bool
+}
+
+// portPurpose describes what a node port is for.
+func portPurpose(nodeport int) string {
+	switch nodeport {
+	case 22:
+		return "SSH"
+	case 6443:
+		return "Kubernetes API server"
+	default:
+		return "published"
+	}
+}
+
+// portViews returns a view of each claim, marking host ports that are
+// claimed more than once.
+func portViews(claims []*clustercmd.HostPortClaim) []*portview {
+	counts := map[int]int{}
+	for _, claim := range claims {
+		counts[claim.HostPort]++
+	}
+
+	result := make([]*portview, len(claims))
+	for i, claim := range claims {
+		result[i] = &portview{
+			HostPort: claim.HostPort,
+			Cluster:  claim.Cluster,
+			Node:     claim.Node,
+			NodePort: claim.NodePort,
+			Purpose:  portPurpose(claim.NodePort),
+			Conflict: counts[claim.HostPort] > 1,
+		}
+	}
+
+	return result
+}
+
+// conflictMessages describes each conflicting host port, in host port
+// order. Views must be ordered by host port.
+func conflictMessages(views []*portview) []string {
+	result := []string{}
+	for i := 0; i < len(views); {
+		j := i
+		claimants := []string{}
+		for ; j < len(views) && views[j].HostPort == views[i].HostPort; j++ {
+			claimants = append(
+				claimants,
+				fmt.Sprintf("%v/%v:%v", views[j].Cluster, views[j].Node, views[j].NodePort),
+			)
+		}
+
+		if len(claimants) > 1 {
+			result = append(result, fmt.Sprintf(
+				"host port %v is claimed by %v",
+				views[i].HostPort,
+				strings.Join(claimants, ", "),
+			))
+		}
+		i = j
+	}
+
+	return result
+}
+
+func portsCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	output, _ := c.Flags().GetString("output")
+	if output != "table" && output != "json" {
+		return cli.WrapErrorMessagef(
+			1,
+			"unknown output format '%v'. Use table or json",
+			output,
+		)
+	}
+
+	views := portViews(clustercmd.HostPortClaims())
+
+	conflictsonly, _ := c.Flags().GetBool("conflicts")
+	if conflictsonly {
+		conflicts := make([]*portview, 0, len(views))
+		for _, view := range views {
+			if view.Conflict {
+				conflicts = append(conflicts, view)
+			}
+		}
+		views = conflicts
+	}
+
+	if output == "json" {
+		renderer := cli.NewJSONRenderer(2)
+		renderer.Render(os.Stdout, views)
+		return nil
+	}
+
+	// Mark conflicts legibly in the table
+	type portrow struct {
+		portview
+		Conflict string
+	}
+	rows := make([]*portrow, len(views))
+	for i, view := range views {
+		rows[i] = &portrow{portview: *view, Conflict: "-"}
+		if view.Conflict {
+			rows[i].Conflict = "yes"
+		}
+	}
+
+	var portsFormatter = cli.NewTableRenderer(
+		"ports",
+		[]*cli.TableColumn{
+			{Name: "HostPort", Title: "Host Port", Width: 10},
+			{Name: "Cluster", Width: 12},
+			{Name: "Node", Width: 12},
+			{Name: "NodePort", Title: "Node Port", Width: 10},
+			{Name: "Purpose", Width: 22},
+			{Name: "Conflict", Width: 8},
+		},
+		"",
+	)
+	portsFormatter.Render(os.Stdout, rows)
+
+	for _, message := range conflictMessages(views) {
+		kuttilog.Printf(kuttilog.Info, "Warning: %v.", message)
+	}
+
+	return nil
+}
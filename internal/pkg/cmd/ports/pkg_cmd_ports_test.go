@@ -0,0 +1,34 @@
+package ports
+
+import (
+	"testing"
+
+	clustercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/cluster"
+)
+
+func TestPortConflicts(t *testing.T) {
+	claims := []*clustercmd.HostPortClaim{
+		{HostPort: 8080, Cluster: "dev", Node: "worker1", NodePort: 80},
+		{HostPort: 10022, Cluster: "dev", Node: "control", NodePort: 22},
+		{HostPort: 10022, Cluster: "test", Node: "control", NodePort: 22},
+		{HostPort: 16443, Cluster: "dev", Node: "control", NodePort: 6443},
+	}
+
+	views := portViews(claims)
+
+	expected := []bool{false, true, true, false}
+	for i, view := range views {
+		if view.Conflict != expected[i] {
+			t.Fatalf("host port %v of %v/%v: expected conflict %v", view.HostPort, view.Cluster, view.Node, expected[i])
+		}
+	}
+
+	if views[3].Purpose != "Kubernetes API server" {
+		t.Fatalf("expected API server purpose, got '%v'", views[3].Purpose)
+	}
+
+	messages := conflictMessages(views)
+	if len(messages) != 1 || messages[0] != "host port 10022 is claimed by dev/control:22, test/control:22" {
+		t.Fatalf("unexpected conflict messages: %v", messages)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/kuttiproject/kutti/internal/pkg/cmd/completions"
 	"github.com/kuttiproject/kutti/internal/pkg/cmd/driver"
 	"github.com/kuttiproject/kutti/internal/pkg/cmd/node"
+	"github.com/kuttiproject/kutti/internal/pkg/cmd/ports"
 	"github.com/kuttiproject/kutti/internal/pkg/cmd/setting"
 	"github.com/kuttiproject/kutti/internal/pkg/cmd/version"
 
@@ -31,6 +32,7 @@ var rootCmd = &cli.Command{
 		version.CommandTree(),
 		cluster.CommandTree(),
 		node.CommandTree(),
+		ports.CommandTree(),
 		// Add more commands here
 	},
 }
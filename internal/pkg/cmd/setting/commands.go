@@ -21,7 +21,7 @@ var configcommand = &cli.Command{
 				Aliases:               []string{"list"},
 				Args:                  cobra.NoArgs,
 				Short:                 "Shows all configuration settings",
-				Long:                  `Shows all configuration settings.`,
+				Long:                  `Shows all configuration settings. The values of passwords are masked.`,
 				Run:                   configlsCommand,
 				DisableFlagsInUseLine: true,
 			},
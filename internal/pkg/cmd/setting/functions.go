@@ -20,7 +20,13 @@ func configlsCommand(cmd *cobra.Command, args []string) {
 		},
 	)
 
-	configlsFormatter.Render(os.Stdout, cli.Settings())
+	// Secrets, like SSH passwords, are masked
+	settings := map[string]string{}
+	for name, value := range cli.Settings() {
+		settings[name] = cli.MaskSetting(name, value)
+	}
+
+	configlsFormatter.Render(os.Stdout, settings)
 }
 
 func configGetCommand(c *cobra.Command, args []string) error {
@@ -52,11 +58,11 @@ func configSetCommand(c *cobra.Command, args []string) error {
 	}
 
 	if kuttilog.V(kuttilog.Verbose) {
-		kuttilog.Printf(kuttilog.Verbose, "Setting %v set to %v.\n", setting, value)
+		kuttilog.Printf(kuttilog.Verbose, "Setting %v set to %v.\n", setting, cli.MaskSetting(setting, value))
 		return nil
 	}
 
-	fmt.Println(value)
+	fmt.Println(cli.MaskSetting(setting, value))
 	return nil
 }
 
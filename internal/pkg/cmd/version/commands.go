@@ -2,6 +2,7 @@ package version
 
 import (
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	drivercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/driver"
 
 	"github.com/spf13/cobra"
 )
@@ -59,11 +60,40 @@ var versionCmd = &cli.Command{
 		},
 		{
 			Cmd: &cobra.Command{
-				Use:                   "pull [flags] K8SVERSION",
-				Aliases:               []string{"fetch", "get"},
-				Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
-				ValidArgsFunction:     NameValidArgs,
-				Short:                 "Download version image",
+				Use:               "pull [flags] K8SVERSION",
+				Aliases:           []string{"fetch", "get"},
+				Args:              cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+				ValidArgsFunction: NameValidArgs,
+				Short:             "Download version image",
+				Long: `
+Download version image.
+
+If the driver uses a version catalog, set up with 'kutti driver update
+--catalog', kutti downloads the image from the location in the catalog to
+its cache, and verifies it against the SHA-256 checksum in the catalog
+before importing it. An image that fails verification is discarded, and
+never imported. If a download is interrupted, running the command again
+resumes it from where it stopped, if the server supports it.
+
+Resumed and verified downloads depend on the catalog, since drivers do not
+publish the checksums of their images any other way. If the driver does
+not use a catalog, the command fails, unless --unverified is specified.
+In that case, the driver downloads the image itself. Such a download
+cannot be resumed, and is not verified.
+
+With --fromfile, an image file is imported. If the file has a manifest
+next to it, named like the file with .manifest.json appended, the image is
+validated against it. The manifest is a JSON object with the fields Driver,
+K8sVersion and SHA256. The image is rejected if it is for a different
+driver or Kubernetes version, or does not match the checksum. Images
+without a manifest are imported without validation, unless --manifest is
+specified.
+
+Examples:
+	kutti version pull 1.29
+	kutti version pull 1.29 --unverified
+	kutti version pull 1.29 --fromfile ./kutti-1.29.ova
+`,
 				RunE:                  versionPullCommand,
 				SilenceErrors:         true,
 				DisableFlagsInUseLine: true,
@@ -71,8 +101,11 @@ var versionCmd = &cli.Command{
 			SetFlagsFunc: func(c *cobra.Command) {
 				SetDriverFlag(c)
 
+				c.Flags().Bool("unverified", false, "let the driver download the image if it has no version catalog, without resume or verification")
 				c.Flags().StringP("fromfile", "f", "", "local file path to import version image from")
 				c.MarkFlagFilename("fromfile")
+				c.Flags().String("manifest", "", "manifest to validate the imported image against (default is the file path with .manifest.json appended)")
+				c.MarkFlagFilename("manifest")
 			},
 		},
 		{
@@ -89,14 +122,60 @@ var versionCmd = &cli.Command{
 		},
 		{
 			Cmd: &cobra.Command{
-				Use:           "update",
-				Args:          cobra.NoArgs,
-				Short:         "Update image list",
+				Use:   "prune",
+				Args:  cobra.NoArgs,
+				Short: "Remove unused version images",
+				Long: `
+Remove unused version images.
+
+Removes the downloaded images of a driver that are not used by any cluster.
+The images to be removed are shown first, and are removed only after
+confirmation. Their sizes are not shown, because drivers do not report
+where they store images.
+
+Examples:
+	kutti version prune
+	kutti version prune --keep-latest 2
+	kutti version prune --deprecated-only --yes
+`,
+				RunE:          versionPruneCommand,
+				SilenceErrors: true,
+			},
+			SetFlagsFunc: func(c *cobra.Command) {
+				SetDriverFlag(c)
+
+				c.Flags().Int("keep-latest", 0, "keep the images of the latest N downloaded versions, even if unused")
+				c.Flags().Bool("deprecated-only", false, "remove only images of deprecated versions")
+				c.Flags().BoolP("dry-run", "n", false, "show the images that would be removed, without removing them")
+				c.Flags().BoolP("yes", "y", false, "remove without asking for confirmation")
+			},
+		},
+		{
+			Cmd: &cobra.Command{
+				Use:   "update",
+				Args:  cobra.NoArgs,
+				Short: "Update image list",
+				Long: `
+Update image list.
+
+Works like 'kutti driver update', for the driver specified by --driver, or
+the default driver. If a version catalog is specified with --catalog, or
+with the catalog-DRIVERNAME setting, the catalog is used instead of the
+public source of the driver, and images are downloaded from the locations
+it lists.
+
+Examples:
+	kutti version update
+	kutti version update --catalog http://mirror.example.com/vbox/catalog.json
+`,
 				RunE:          versionUpdateCommand,
 				SilenceErrors: true,
 				SilenceUsage:  true,
 			},
-			SetFlagsFunc: SetDriverFlag,
+			SetFlagsFunc: func(c *cobra.Command) {
+				SetDriverFlag(c)
+				drivercmd.SetCatalogFlag(c)
+			},
 		},
 	},
 }
@@ -1,14 +1,20 @@
 package version
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/kuttiproject/kuttilog"
 
 	"github.com/kuttiproject/kuttilib"
 
+	"github.com/kuttiproject/kutti/internal/pkg/catalog"
 	"github.com/kuttiproject/kutti/internal/pkg/cli"
+	drivercmd "github.com/kuttiproject/kutti/internal/pkg/cmd/driver"
+	"github.com/kuttiproject/kutti/internal/pkg/download"
 
 	"github.com/spf13/cobra"
 )
@@ -139,12 +145,99 @@ func versionUnselectCommand(c *cobra.Command, args []string) error {
 	return cli.RemoveDefault("version")
 }
 
+// downloadProgress returns a progress function that shows downloaded
+// MiB on one line.
+func downloadProgress() func(current int64, total int64) {
+	fmt.Print("    Starting download...")
+	prevMib := int64(-1)
+	return func(current int64, total int64) {
+		currentMib := current / 1048576
+		if currentMib > prevMib || current == total {
+			if total < 0 {
+				fmt.Printf("\r    Downloaded %v MiB", currentMib)
+			} else {
+				fmt.Printf("\r    Downloaded %v/%v MiB", currentMib, total/1048576)
+			}
+			prevMib = currentMib
+		}
+		if current == total {
+			fmt.Println()
+		}
+	}
+}
+
+// pullImage downloads the image of a version into the kutti cache,
+// resuming any earlier partial download, verifies its checksum, and then
+// imports it. A corrupt download is discarded, and never imported. If the
+// source is nil, the driver downloads the image itself, and the download
+// cannot be resumed or verified by kutti.
+func pullImage(driver *kuttilib.Driver, version *kuttilib.Version, source *imagesource) error {
+	if source == nil {
+		kuttilog.Printf(
+			kuttilog.Minimal,
+			"Warning: driver '%v' does not use a version catalog, so the driver downloads the image itself. "+
+				"The download cannot be resumed, and the image is not verified against a checksum.",
+			driver.Name(),
+		)
+		if kuttilog.V(kuttilog.Info) {
+			return version.FetchWithProgress(downloadProgress())
+		}
+
+		return version.Fetch()
+	}
+
+	// Catalogs always have checksums
+	checksum, err := download.NormalizeSHA256(source.SHA256)
+	if err != nil {
+		return err
+	}
+
+	// Images in catalogs loaded from files are local files, which are
+	// imported directly.
+	if !catalog.IsURL(source.URL) {
+		kuttilog.Printf(kuttilog.Info, "Verifying image %v...", source.URL)
+		err := download.VerifyFile(source.URL, checksum)
+		if err != nil {
+			return fmt.Errorf("image is corrupt, and has not been imported: %v", err)
+		}
+
+		return version.FromFile(source.URL)
+	}
+
+	imagepath, err := downloadPath(driver, version, source.URL)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(imagepath + download.PartialSuffix); err == nil {
+		kuttilog.Println(kuttilog.Info, "Resuming earlier download.")
+	}
+
+	options := &download.Options{SHA256: checksum}
+	if kuttilog.V(kuttilog.Info) {
+		options.Progress = downloadProgress()
+	}
+
+	err = download.File(source.URL, imagepath, options)
+	if errors.Is(err, download.ErrChecksumMismatch) {
+		return fmt.Errorf("downloaded image is corrupt, and has been discarded: %v", err)
+	}
+	if err != nil {
+		return fmt.Errorf("%v. Run the command again to resume", err)
+	}
+	defer os.Remove(imagepath)
+
+	kuttilog.Println(kuttilog.Verbose, "Image checksum verified.")
+
+	return version.FromFile(imagepath)
+}
+
 func versionPullCommand(c *cobra.Command, args []string) error {
 	c.SilenceUsage = true
 
 	versionname := args[0]
 
-	version, _, err := GetVersion(c, versionname)
+	version, driver, err := GetVersion(c, versionname)
 	if err != nil {
 		return err
 	}
@@ -153,21 +246,19 @@ func versionPullCommand(c *cobra.Command, args []string) error {
 	if err != nil || filename == "" {
 		kuttilog.Printf(kuttilog.Minimal, "Downloading image for Kubernetes version %s...", versionname)
 
-		if kuttilog.V(kuttilog.Info) {
-			fmt.Print("    Starting download...")
-			prevMib := int64(0)
-			err = version.FetchWithProgress(func(current int64, total int64) {
-				currentMib := current / 1048576
-				if (current < 1048576) || currentMib > prevMib {
-					fmt.Printf("\r    Downloaded %v/%v MiB", currentMib, total/1048576)
-					prevMib = currentMib
-				}
-				if current == total {
-					fmt.Println()
-				}
-			})
-		} else {
-			err = version.Fetch()
+		unverified, _ := c.Flags().GetBool("unverified")
+
+		source, err := versionImageSource(driver, version)
+		if err == nil && source == nil && !unverified {
+			err = fmt.Errorf(
+				"driver '%v' does not use a version catalog, so the image cannot be verified. "+
+					"Use 'kutti driver update --catalog' to download images from a catalog, "+
+					"or --unverified to let the driver download the image itself",
+				driver.Name(),
+			)
+		}
+		if err == nil {
+			err = pullImage(driver, version, source)
 		}
 
 		if err != nil {
@@ -188,6 +279,33 @@ func versionPullCommand(c *cobra.Command, args []string) error {
 		return nil
 	}
 
+	manifestfile, _ := c.Flags().GetString("manifest")
+	manifestrequired := manifestfile != ""
+	if !manifestrequired {
+		manifestfile = manifestPath(filename)
+	}
+
+	manifest, err := readManifest(manifestfile)
+	if errors.Is(err, os.ErrNotExist) && !manifestrequired {
+		kuttilog.Printf(
+			kuttilog.Info,
+			"Warning: manifest %v not found. The image will not be validated.",
+			manifestfile,
+		)
+	} else {
+		if err == nil {
+			kuttilog.Printf(kuttilog.Info, "Validating image against manifest %v...", manifestfile)
+			err = validateManifest(manifest, filename, driver.Name(), version.K8sVersion())
+		}
+		if err != nil {
+			return cli.WrapErrorMessagef(
+				1,
+				"image rejected: %v",
+				err,
+			)
+		}
+	}
+
 	kuttilog.Printf(kuttilog.Info, "Importing image for version %v...", versionname)
 	err = version.FromFile(filename)
 	if err != nil {
@@ -241,5 +359,118 @@ func versionUpdateCommand(c *cobra.Command, args []string) error {
 		return err
 	}
 
-	return driver.UpdateVersionList()
+	return drivercmd.UpdateVersions(c, driver)
+}
+
+func versionPruneCommand(c *cobra.Command, args []string) error {
+	c.SilenceUsage = true
+
+	driver, err := getDriver(c)
+	if err != nil {
+		return err
+	}
+
+	keeplatest, _ := c.Flags().GetInt("keep-latest")
+	if keeplatest < 0 {
+		return cli.WrapErrorMessagef(
+			1,
+			"invalid value %v for --keep-latest: cannot be negative",
+			keeplatest,
+		)
+	}
+	deprecatedonly, _ := c.Flags().GetBool("deprecated-only")
+	dryrun, _ := c.Flags().GetBool("dry-run")
+	yes, _ := c.Flags().GetBool("yes")
+
+	downloaded := []*pruneview{}
+	for _, version := range driver.Versions() {
+		if version.Status() != kuttilib.VersionStatusDownloaded {
+			continue
+		}
+
+		downloaded = append(downloaded, &pruneview{
+			K8sVersion: version.K8sVersion(),
+			Deprecated: version.Deprecated(),
+			version:    version,
+		})
+	}
+	sort.Slice(downloaded, func(i, j int) bool {
+		return compareK8sVersions(downloaded[i].K8sVersion, downloaded[j].K8sVersion) > 0
+	})
+
+	used := clusterVersions(driver.Name())
+	for _, image := range downloaded {
+		if clusternames, ok := used[image.K8sVersion]; ok {
+			kuttilog.Printf(
+				kuttilog.Verbose,
+				"Keeping image for Kubernetes version %v, used by: %v.",
+				image.K8sVersion,
+				strings.Join(clusternames, ", "),
+			)
+		}
+	}
+
+	images := pruneVersions(downloaded, used, keeplatest, deprecatedonly)
+	if len(images) == 0 {
+		kuttilog.Println(kuttilog.Info, "No images to remove.")
+		return nil
+	}
+
+	// The images are always shown before asking for confirmation
+	if dryrun || !yes || kuttilog.V(kuttilog.Minimal) {
+		prunerenderer := cli.NewTableRenderer(
+			"versionprune",
+			[]*cli.TableColumn{
+				{Name: "K8sVersion", Title: "K8s Version", Width: 15},
+				{Name: "Deprecated", Width: 15},
+			},
+			"",
+		)
+		prunerenderer.Render(os.Stdout, images)
+		fmt.Printf("%v images.\n", len(images))
+	}
+
+	if dryrun {
+		return nil
+	}
+
+	if !yes && !cli.Confirm("Remove these images?") {
+		kuttilog.Println(kuttilog.Minimal, "No images removed.")
+		return nil
+	}
+
+	failed := 0
+	for _, image := range images {
+		kuttilog.Printf(kuttilog.Info, "Removing image for Kubernetes version '%v'...", image.K8sVersion)
+		err := image.version.PurgeLocal()
+		if err != nil {
+			kuttilog.Printf(
+				kuttilog.Quiet,
+				"Error: could not remove image for Kubernetes version '%v': %v",
+				image.K8sVersion,
+				err,
+			)
+			failed++
+			continue
+		}
+
+		if kuttilog.V(kuttilog.Info) {
+			kuttilog.Printf(kuttilog.Info, "Removed image for Kubernetes version '%v'.", image.K8sVersion)
+		} else {
+			kuttilog.Println(kuttilog.Minimal, image.K8sVersion)
+		}
+	}
+
+	if failed > 0 {
+		return cli.WrapErrorMessagef(
+			1,
+			"could not remove %v of %v images",
+			failed,
+			len(images),
+		)
+	}
+
+	kuttilog.Printf(kuttilog.Minimal, "Removed %v images.", len(images))
+
+	return nil
 }
@@ -0,0 +1,74 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/kuttiproject/kuttilib"
+	"github.com/kuttiproject/workspace"
+
+	"github.com/kuttiproject/kutti/internal/pkg/catalog"
+)
+
+const downloadsdirname = "downloads"
+
+// imagesource is where the image of a version can be downloaded from, and
+// its expected SHA-256 checksum.
+type imagesource struct {
+	URL    string
+	SHA256 string
+}
+
+// versionImageSource returns the image source of a version from the
+// catalog of the driver. kuttilib does not expose where drivers download
+// images from, or their checksums, so verified downloads depend on the
+// catalog. If the driver does not use one, the source is nil, and the
+// image can only be downloaded, unverified, by the driver itself.
+// Versions not in the catalog of a driver are an error.
+func versionImageSource(driver *kuttilib.Driver, version *kuttilib.Version) (*imagesource, error) {
+	saved, err := catalog.Saved(driver.Name())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := saved.Get(version.K8sVersion())
+	if !ok {
+		return nil, fmt.Errorf(
+			"version %v is not in the catalog of driver '%v'",
+			version.K8sVersion(),
+			driver.Name(),
+		)
+	}
+
+	return &imagesource{
+		URL:    entry.ImageURL,
+		SHA256: entry.SHA256,
+	}, nil
+}
+
+// downloadPath returns the path in the kutti cache where the image of a
+// version is downloaded to, before it is imported by the driver. The
+// extension of the source is kept, since drivers may depend on it.
+func downloadPath(driver *kuttilib.Driver, version *kuttilib.Version, sourceurl string) (string, error) {
+	downloadsdir, err := workspace.Cachesubdir(downloadsdirname)
+	if err != nil {
+		return "", err
+	}
+
+	extension := ""
+	if parsed, err := url.Parse(sourceurl); err == nil {
+		extension = path.Ext(parsed.Path)
+	}
+
+	return filepath.Join(
+		downloadsdir,
+		driver.Name()+"-"+version.K8sVersion()+extension,
+	), nil
+}
@@ -0,0 +1,69 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kuttiproject/kutti/internal/pkg/download"
+)
+
+// manifestsuffix is appended to the path of an image file to get the path
+// of its manifest.
+const manifestsuffix = ".manifest.json"
+
+// imagemanifest describes a version image file, so that it can be
+// validated when imported.
+type imagemanifest struct {
+	Driver     string
+	K8sVersion string
+	SHA256     string
+}
+
+// manifestPath returns the path of the manifest of an image file.
+func manifestPath(imagepath string) string {
+	return imagepath + manifestsuffix
+}
+
+// readManifest reads an image manifest.
+func readManifest(path string) (*imagemanifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &imagemanifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %v: %v", path, err)
+	}
+
+	return manifest, nil
+}
+
+// validateManifest checks that an image file matches its manifest, and
+// that the manifest is for the specified driver and Kubernetes version.
+func validateManifest(manifest *imagemanifest, imagepath string, drivername string, k8sversion string) error {
+	if manifest.Driver != drivername {
+		return fmt.Errorf(
+			"image is for driver '%v', not '%v'",
+			manifest.Driver,
+			drivername,
+		)
+	}
+
+	if manifest.K8sVersion != k8sversion {
+		return fmt.Errorf(
+			"image is for Kubernetes version %v, not %v",
+			manifest.K8sVersion,
+			k8sversion,
+		)
+	}
+
+	err := download.VerifyFile(imagepath, manifest.SHA256)
+	if err != nil {
+		return fmt.Errorf("image does not match its manifest: %v", err)
+	}
+
+	return nil
+}
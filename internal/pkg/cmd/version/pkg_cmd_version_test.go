@@ -0,0 +1,97 @@
+package version
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneVersions(t *testing.T) {
+	downloaded := []*pruneview{
+		{K8sVersion: "1.30"},
+		{K8sVersion: "1.29"},
+		{K8sVersion: "1.28", Deprecated: true},
+		{K8sVersion: "1.27", Deprecated: true},
+		{K8sVersion: "1.26", Deprecated: true},
+	}
+	used := map[string][]string{"1.27": {"dev"}}
+
+	testCases := []struct {
+		keeplatest     int
+		deprecatedonly bool
+		expected       string
+	}{
+		{keeplatest: 0, expected: "1.30,1.29,1.28,1.26"},
+		{keeplatest: 2, expected: "1.28,1.26"},
+		{keeplatest: 0, deprecatedonly: true, expected: "1.28,1.26"},
+		{keeplatest: 3, deprecatedonly: true, expected: "1.26"},
+		{keeplatest: 10, expected: ""},
+	}
+
+	for _, tc := range testCases {
+		names := []string{}
+		for _, image := range pruneVersions(downloaded, used, tc.keeplatest, tc.deprecatedonly) {
+			names = append(names, image.K8sVersion)
+		}
+
+		actual := strings.Join(names, ",")
+		if actual != tc.expected {
+			t.Fatalf("keep %v, deprecated only %v: expected '%v', got '%v'", tc.keeplatest, tc.deprecatedonly, tc.expected, actual)
+		}
+	}
+
+	if compareK8sVersions("1.9", "1.10") >= 0 || compareK8sVersions("1.29.1", "1.29") <= 0 {
+		t.Fatalf("versions not compared numerically")
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	content := []byte("kutti image")
+	sum := sha256.Sum256(content)
+
+	imagepath := filepath.Join(t.TempDir(), "kutti-vbox-1.29.ova")
+	os.WriteFile(imagepath, content, 0644)
+
+	data, _ := json.Marshal(&imagemanifest{
+		Driver:     "vbox",
+		K8sVersion: "1.29",
+		SHA256:     hex.EncodeToString(sum[:]),
+	})
+	os.WriteFile(manifestPath(imagepath), data, 0644)
+
+	manifest, err := readManifest(manifestPath(imagepath))
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		driver      string
+		k8sversion  string
+		content     []byte
+		expectederr string
+	}{
+		{name: "valid", driver: "vbox", k8sversion: "1.29"},
+		{name: "wrong driver", driver: "hyperv", k8sversion: "1.29", expectederr: "for driver 'vbox'"},
+		{name: "wrong version", driver: "vbox", k8sversion: "1.30", expectederr: "Kubernetes version 1.29"},
+		{name: "tampered", driver: "vbox", k8sversion: "1.29", content: []byte("kutti imagf"), expectederr: "does not match"},
+	}
+
+	for _, tc := range testCases {
+		if tc.content != nil {
+			os.WriteFile(imagepath, tc.content, 0644)
+		}
+
+		err := validateManifest(manifest, imagepath, tc.driver, tc.k8sversion)
+		if tc.expectederr == "" && err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.name, err)
+		}
+		if tc.expectederr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectederr)) {
+			t.Fatalf("%v: expected error containing '%v', got %v", tc.name, tc.expectederr, err)
+		}
+	}
+}
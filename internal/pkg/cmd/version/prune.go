@@ -0,0 +1,84 @@
+package version
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/kuttilib"
+)
+
+// pruneview is a downloaded version image, as considered by prune.
+type pruneview struct {
+	K8sVersion string
+	Deprecated bool
+	version    *kuttilib.Version
+}
+
+// compareK8sVersions compares two Kubernetes version strings numerically,
+// part by part. It returns a negative number if a is older than b, zero if
+// they are the same, and a positive number if a is newer.
+func compareK8sVersions(a string, b string) int {
+	aparts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bparts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(aparts) || i < len(bparts); i++ {
+		var anumber, bnumber int
+		if i < len(aparts) {
+			anumber, _ = strconv.Atoi(aparts[i])
+		}
+		if i < len(bparts) {
+			bnumber, _ = strconv.Atoi(bparts[i])
+		}
+
+		if anumber != bnumber {
+			return anumber - bnumber
+		}
+	}
+
+	return 0
+}
+
+// clusterVersions returns the Kubernetes versions used by the clusters of
+// a driver, with the names of the clusters using each.
+func clusterVersions(drivername string) map[string][]string {
+	result := map[string][]string{}
+	for clustername, cluster := range kuttilib.Clusters() {
+		if cluster.DriverName() != drivername {
+			continue
+		}
+
+		k8sversion := cluster.K8sVersion()
+		result[k8sversion] = append(result[k8sversion], clustername)
+	}
+
+	for _, clusternames := range result {
+		sort.Strings(clusternames)
+	}
+
+	return result
+}
+
+// pruneVersions returns the downloaded images that can be removed. Images
+// used by a cluster are never removed. The newest keeplatest images are
+// kept whether used or not. If deprecatedonly is set, only images of
+// deprecated versions are removed. The downloaded images must be ordered
+// newest first.
+func pruneVersions(downloaded []*pruneview, used map[string][]string, keeplatest int, deprecatedonly bool) []*pruneview {
+	result := []*pruneview{}
+	for i, image := range downloaded {
+		if i < keeplatest {
+			continue
+		}
+		if _, ok := used[image.K8sVersion]; ok {
+			continue
+		}
+		if deprecatedonly && !image.Deprecated {
+			continue
+		}
+
+		result = append(result, image)
+	}
+
+	return result
+}
@@ -0,0 +1,285 @@
+// Package download fetches files over HTTP, resuming interrupted downloads
+// and verifying SHA-256 checksums.
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PartialSuffix is appended to the target path while a download is
+// incomplete or unverified.
+const PartialSuffix = ".part"
+
+// ErrChecksumMismatch is returned when a downloaded file does not match
+// its expected checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Options control a download. The zero value downloads without checksum
+// verification or progress reports, using http.DefaultClient.
+type Options struct {
+	// SHA256 is the expected checksum, in hex. If empty, the download is
+	// not verified.
+	SHA256 string
+	// Progress, if not nil, is called as data arrives, with the number
+	// of bytes downloaded so far, including any resumed bytes, and the
+	// total size. The total is -1 if the server does not report it.
+	Progress func(current int64, total int64)
+	// Client is the HTTP client used. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// NormalizeSHA256 returns a SHA-256 checksum as lowercase hex, removing
+// any "sha256:" prefix. It returns an error if the result is not a valid
+// SHA-256 checksum.
+func NormalizeSHA256(checksum string) (string, error) {
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	checksum = strings.TrimPrefix(checksum, "sha256:")
+
+	decoded, err := hex.DecodeString(checksum)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 checksum '%v'", checksum)
+	}
+
+	return checksum, nil
+}
+
+// File downloads a URL to a file. Data is written to the file path with
+// PartialSuffix appended, which is renamed to the file path only after
+// the download completes and the checksum matches. If a partial file
+// exists, the download resumes from its end using an HTTP range request.
+// If the server does not support range requests, the download starts
+// again. If the checksum does not match, the partial file is removed, and
+// ErrChecksumMismatch is returned.
+func File(url string, path string, options *Options) error {
+	if options == nil {
+		options = &Options{}
+	}
+
+	expected := ""
+	if options.SHA256 != "" {
+		var err error
+		expected, err = NormalizeSHA256(options.SHA256)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	partialpath := path + PartialSuffix
+	hasher := sha256.New()
+
+	offset, err := hashExisting(partialpath, hasher)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	total := int64(-1)
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case response.StatusCode == http.StatusPartialContent && offset > 0:
+		start, size, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("server resumed at byte %v instead of %v", start, offset)
+		}
+		total = size
+		flags |= os.O_APPEND
+
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file may already be complete.
+		_, size, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil || size != offset {
+			os.Remove(partialpath)
+			return fmt.Errorf("could not resume download: server returned %v", response.Status)
+		}
+		return finish(partialpath, path, hasher, expected)
+
+	case response.StatusCode == http.StatusOK:
+		// A full response, either because nothing was downloaded yet, or
+		// because the server does not support range requests.
+		offset = 0
+		hasher.Reset()
+		flags |= os.O_TRUNC
+		if response.ContentLength >= 0 {
+			total = response.ContentLength
+		}
+
+	default:
+		return fmt.Errorf("server returned %v", response.Status)
+	}
+
+	file, err := os.OpenFile(partialpath, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = response.Body
+	if options.Progress != nil {
+		options.Progress(offset, total)
+		reader = &progressreader{
+			reader:   response.Body,
+			current:  offset,
+			total:    total,
+			progress: options.Progress,
+		}
+	}
+
+	_, err = io.Copy(io.MultiWriter(file, hasher), reader)
+	closeerr := file.Close()
+	if err != nil {
+		return fmt.Errorf("download interrupted: %v", err)
+	}
+	if closeerr != nil {
+		return closeerr
+	}
+
+	return finish(partialpath, path, hasher, expected)
+}
+
+// hashExisting feeds an existing partial file to the hasher, and returns
+// its size. A missing file has size 0.
+func hashExisting(partialpath string, hasher hash.Hash) (int64, error) {
+	file, err := os.Open(partialpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return io.Copy(hasher, file)
+}
+
+// finish verifies a complete partial file, and moves it into place.
+func finish(partialpath string, path string, hasher hash.Hash, expected string) error {
+	if expected != "" {
+		actual := hex.EncodeToString(hasher.Sum(nil))
+		if actual != expected {
+			os.Remove(partialpath)
+			return fmt.Errorf("%w: expected %v, got %v", ErrChecksumMismatch, expected, actual)
+		}
+	}
+
+	return os.Rename(partialpath, path)
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes START-END/SIZE" or "bytes */SIZE". START is -1 in the second
+// form.
+func parseContentRange(value string) (int64, int64, error) {
+	invalid := fmt.Errorf("invalid Content-Range '%v'", value)
+
+	rangespec, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, invalid
+	}
+
+	span, sizestring, found := strings.Cut(rangespec, "/")
+	if !found {
+		return 0, 0, invalid
+	}
+
+	size, err := strconv.ParseInt(sizestring, 10, 64)
+	if err != nil {
+		return 0, 0, invalid
+	}
+
+	if span == "*" {
+		return -1, size, nil
+	}
+
+	startstring, _, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, invalid
+	}
+
+	start, err := strconv.ParseInt(startstring, 10, 64)
+	if err != nil {
+		return 0, 0, invalid
+	}
+
+	return start, size, nil
+}
+
+type progressreader struct {
+	reader   io.Reader
+	current  int64
+	total    int64
+	progress func(current int64, total int64)
+}
+
+func (p *progressreader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if n > 0 {
+		p.current += int64(n)
+		p.progress(p.current, p.total)
+	}
+
+	return n, err
+}
+
+// FileSHA256 returns the SHA-256 checksum of a file, in hex.
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// VerifyFile checks that a file matches a SHA-256 checksum. If it does not,
+// ErrChecksumMismatch is returned.
+func VerifyFile(path string, checksum string) error {
+	expected, err := NormalizeSHA256(checksum)
+	if err != nil {
+		return err
+	}
+
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return fmt.Errorf("%w: expected %v, got %v", ErrChecksumMismatch, expected, actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testContent() ([]byte, string) {
+	content := bytes.Repeat([]byte("kutti image data "), 10000)
+	sum := sha256.Sum256(content)
+	return content, hex.EncodeToString(sum[:])
+}
+
+func TestFile(t *testing.T) {
+	content, checksum := testContent()
+
+	var lastrange string
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastrange = r.Header.Get("Range")
+		http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ranged.Close()
+
+	// A server that ignores range requests
+	unranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer unranged.Close()
+
+	// A server that drops the connection halfway
+	dropping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:len(content)/2])
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer dropping.Close()
+
+	testCases := []struct {
+		name          string
+		url           string
+		partial       []byte
+		checksum      string
+		expectedrange string
+		expectederr   bool
+		mismatch      bool
+	}{
+		{name: "fresh", url: ranged.URL, checksum: checksum},
+		{name: "resume", url: ranged.URL, partial: content[:1000], checksum: checksum, expectedrange: "bytes=1000-"},
+		{name: "complete partial", url: ranged.URL, partial: content, checksum: checksum, expectedrange: "bytes=" + strconv.Itoa(len(content)) + "-"},
+		{name: "no range support", url: unranged.URL, partial: []byte("stale"), checksum: checksum},
+		{name: "no checksum", url: ranged.URL, checksum: ""},
+		{name: "sha256 prefix", url: ranged.URL, checksum: "sha256:" + checksum},
+		{name: "corrupt partial", url: ranged.URL, partial: []byte("corrupt"), checksum: checksum, expectederr: true, mismatch: true},
+		{name: "wrong checksum", url: ranged.URL, checksum: checksum[1:] + "0", expectederr: true, mismatch: true},
+		{name: "dropped", url: dropping.URL, checksum: checksum, expectederr: true},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(t.TempDir(), "image")
+		if tc.partial != nil {
+			os.WriteFile(path+PartialSuffix, tc.partial, 0644)
+		}
+
+		lastrange = ""
+		var current, total int64
+		err := File(tc.url, path, &Options{
+			SHA256: tc.checksum,
+			Progress: func(c int64, t int64) {
+				current, total = c, t
+			},
+		})
+
+		if tc.expectederr {
+			if err == nil {
+				t.Fatalf("%v: expected error", tc.name)
+			}
+			if errors.Is(err, ErrChecksumMismatch) != tc.mismatch {
+				t.Fatalf("%v: unexpected error: %v", tc.name, err)
+			}
+			if _, err := os.Stat(path); err == nil {
+				t.Fatalf("%v: file should not exist after a failed download", tc.name)
+			}
+			if _, err := os.Stat(path + PartialSuffix); tc.mismatch != (err != nil) {
+				t.Fatalf("%v: partial file should be kept only for interrupted downloads", tc.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if lastrange != tc.expectedrange {
+			t.Fatalf("%v: expected range '%v', got '%v'", tc.name, tc.expectedrange, lastrange)
+		}
+
+		result, _ := os.ReadFile(path)
+		if !bytes.Equal(result, content) {
+			t.Fatalf("%v: downloaded content differs", tc.name)
+		}
+		if _, err := os.Stat(path + PartialSuffix); err == nil {
+			t.Fatalf("%v: partial file should be removed", tc.name)
+		}
+		if tc.name != "complete partial" && (current != int64(len(content)) || total != int64(len(content))) {
+			t.Fatalf("%v: expected final progress %v/%v, got %v/%v", tc.name, len(content), len(content), current, total)
+		}
+	}
+}
+
+func TestResumeAfterDrop(t *testing.T) {
+	content, checksum := testContent()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/3])
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "image")
+	err := File(server.URL, path, &Options{SHA256: checksum})
+	if err == nil {
+		t.Fatalf("expected first download to be interrupted")
+	}
+
+	partial, _ := os.Stat(path + PartialSuffix)
+	if partial == nil || partial.Size() == 0 {
+		t.Fatalf("expected partial file after interruption")
+	}
+
+	err = File(server.URL, path, &Options{SHA256: checksum})
+	if err != nil {
+		t.Fatalf("resumed download failed: %v", err)
+	}
+
+	result, _ := os.ReadFile(path)
+	if !bytes.Equal(result, content) {
+		t.Fatalf("resumed content differs")
+	}
+}
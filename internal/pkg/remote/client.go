@@ -0,0 +1,241 @@
+// Package remote runs commands on kutti nodes over SSH.
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilog"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client runs commands on kutti nodes over SSH.
+// It authenticates with a private key if it has one, and falls back to
+// a password. Unlike sshclient, it streams input and output, and reports
+// the exit status of remote commands.
+type Client struct {
+	username  string
+	password  string
+	signer    ssh.Signer
+	installer bool
+}
+
+// NewWithPassword returns a Client that authenticates with a password.
+func NewWithPassword(username string, password string) *Client {
+	return &Client{
+		username: username,
+		password: password,
+	}
+}
+
+// NewWithKey returns a Client that authenticates with the private key in
+// the specified file, and falls back to the password if that fails. If
+// installkey is true, the public key is added to the authorized keys of
+// the user whenever the password had to be used, so that the key works
+// the next time.
+func NewWithKey(username string, password string, keyfile string, installkey bool) (*Client, error) {
+	signer, err := LoadKey(keyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		username:  username,
+		password:  password,
+		signer:    signer,
+		installer: installkey,
+	}, nil
+}
+
+func (c *Client) config(auth ssh.AuthMethod) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User: c.username,
+		Auth: []ssh.AuthMethod{auth},
+		// Nodes are local VMs, recreated often.
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+}
+
+// Dial opens an SSH connection to the specified address.
+func (c *Client) Dial(address string) (*ssh.Client, error) {
+	if c.signer != nil {
+		client, err := ssh.Dial("tcp", address, c.config(ssh.PublicKeys(c.signer)))
+		if err == nil {
+			return client, nil
+		}
+
+		// Only fall back to the password if the node could be reached
+		var operr *net.OpError
+		if c.password == "" || errors.As(err, &operr) {
+			return nil, err
+		}
+
+		kuttilog.Printf(kuttilog.Debug, "Key authentication to %v failed: %v", address, err)
+	}
+
+	client, err := ssh.Dial("tcp", address, c.config(ssh.Password(c.password)))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.signer != nil && c.installer {
+		err = installPublicKey(client, c.signer.PublicKey())
+		if err != nil {
+			kuttilog.Printf(kuttilog.Verbose, "Warning: could not install SSH key at %v: %v.", address, err)
+		} else {
+			kuttilog.Printf(kuttilog.Verbose, "Installed SSH key at %v.", address)
+		}
+	}
+
+	return client, nil
+}
+
+// InstallKey adds the public key of the client to the authorized keys of
+// the user at the specified address, connecting with the password, unless
+// the key is already accepted.
+func (c *Client) InstallKey(address string) error {
+	if c.signer == nil {
+		return errors.New("no private key to install")
+	}
+
+	client, err := ssh.Dial("tcp", address, c.config(ssh.PublicKeys(c.signer)))
+	if err == nil {
+		client.Close()
+		return nil
+	}
+
+	client, err = ssh.Dial("tcp", address, c.config(ssh.Password(c.password)))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return installPublicKey(client, c.signer.PublicKey())
+}
+
+// installPublicKey adds a public key to the authorized keys of the user,
+// unless it is already there.
+func installPublicKey(client *ssh.Client, publickey ssh.PublicKey) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	keyline := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publickey)))
+	return session.Run(fmt.Sprintf(
+		"mkdir -p ~/.ssh && chmod 700 ~/.ssh && touch ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys && "+
+			"(grep -qxF %[1]v ~/.ssh/authorized_keys || echo %[1]v >> ~/.ssh/authorized_keys)",
+		ShellQuote(keyline),
+	))
+}
+
+// Run runs a command at the specified address, connecting the specified
+// reader and writers to its standard input, output and error. Any of them
+// may be nil. It returns the exit status of the command. The error is
+// non-nil only if the command could not be run, or did not report a status.
+func (c *Client) Run(address string, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	client, err := c.Dial(address)
+	if err != nil {
+		return -1, err
+	}
+	defer client.Close()
+
+	return runSession(client, command, stdin, stdout, stderr)
+}
+
+func runSession(client *ssh.Client, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, err
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	// Stdin is copied separately, so that a command which has exited is
+	// not kept waiting for input that it will never read.
+	if stdin != nil {
+		stdinpipe, err := session.StdinPipe()
+		if err != nil {
+			return -1, err
+		}
+
+		go func() {
+			io.Copy(stdinpipe, stdin)
+			stdinpipe.Close()
+		}()
+	}
+
+	err = session.Run(command)
+	if err == nil {
+		return 0, nil
+	}
+
+	var exiterr *ssh.ExitError
+	if errors.As(err, &exiterr) {
+		return exiterr.ExitStatus(), nil
+	}
+
+	return -1, err
+}
+
+// RunWithResults runs a command at the specified address, and returns
+// its standard output as lines. If the command exits with a nonzero
+// status, the error includes its standard error.
+func (c *Client) RunWithResults(address string, command string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	exitstatus, err := c.Run(address, command, nil, &stdout, &stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	if exitstatus != 0 {
+		return nil, fmt.Errorf(
+			"command exited with status %v: %v",
+			exitstatus,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	output := strings.TrimRight(stdout.String(), "\n")
+	if output == "" {
+		return []string{}, nil
+	}
+
+	return strings.Split(output, "\n"), nil
+}
+
+// Sudo wraps a command so that it runs as root, supplying the password
+// of the client to sudo on standard input. Any shell expansion in the
+// command happens as the SSH user.
+func (c *Client) Sudo(command string) string {
+	return fmt.Sprintf(
+		"echo %s | sudo -S -p '' %s",
+		ShellQuote(c.password),
+		command,
+	)
+}
+
+// Redact returns a command with the password of the client, as supplied
+// by Sudo, masked, so that the command can be logged.
+func (c *Client) Redact(command string) string {
+	if c.password == "" {
+		return command
+	}
+
+	return strings.ReplaceAll(command, ShellQuote(c.password), "'********'")
+}
+
+// ShellQuote quotes a string for use as a single word in a POSIX shell
+// command line.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,434 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// CopyOptions control how files are copied. The zero value copies a
+// single file, without checks.
+type CopyOptions struct {
+	// Recurse allows directories to be copied.
+	Recurse bool
+	// Resume skips files whose size and SHA-256 checksum already match
+	// on the target.
+	Resume bool
+	// Verify compares the SHA-256 checksums of the copied files on both
+	// sides after the copy.
+	Verify bool
+	// Progress, if not nil, is called about once a second while data is
+	// copied, with the bytes copied so far and the total. The bytes
+	// copied are estimated from the growth of the target. It is called
+	// with current equal to total exactly once, when the copy completes.
+	Progress func(current int64, total int64)
+}
+
+// CopyTo copies a local file or directory to the specified address.
+// Like scp, if the remote path is an existing directory, the source is
+// copied into it; otherwise, the source is copied as the remote path.
+func (c *Client) CopyTo(address string, localpath string, remotepath string, options *CopyOptions) error {
+	client, err := c.Dial(address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return copyTree(
+		&copytree{
+			source:     &endpoint{},
+			sourcepath: localpath,
+			target:     &endpoint{client: client},
+			targetpath: remotepath,
+			transfer: func(sourcepath string, targetpath string, recurse bool) error {
+				return scpUpload(client, sourcepath, targetpath, recurse)
+			},
+		},
+		options,
+	)
+}
+
+// CopyFrom copies a file or directory from the specified address to a
+// local path. Like scp, if the local path is an existing directory, the
+// source is copied into it; otherwise, the source is copied as the local
+// path.
+func (c *Client) CopyFrom(address string, remotepath string, localpath string, options *CopyOptions) error {
+	client, err := c.Dial(address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return copyTree(
+		&copytree{
+			source:     &endpoint{client: client},
+			sourcepath: remotepath,
+			target:     &endpoint{},
+			targetpath: localpath,
+			transfer: func(sourcepath string, targetpath string, recurse bool) error {
+				return scpDownload(client, sourcepath, targetpath, recurse)
+			},
+		},
+		options,
+	)
+}
+
+// CopyBetween copies a file or directory from one address to another,
+// through a temporary directory on the host. The target path is treated
+// as in CopyTo.
+func (c *Client) CopyBetween(sourceaddress string, sourcepath string, targetaddress string, targetpath string, options *CopyOptions) error {
+	sourceclient, err := c.Dial(sourceaddress)
+	if err != nil {
+		return err
+	}
+	defer sourceclient.Close()
+
+	targetclient, err := c.Dial(targetaddress)
+	if err != nil {
+		return err
+	}
+	defer targetclient.Close()
+
+	return copyTree(
+		&copytree{
+			source:     &endpoint{client: sourceclient},
+			sourcepath: sourcepath,
+			target:     &endpoint{client: targetclient},
+			targetpath: targetpath,
+			transfer: func(sourcepath string, targetpath string, recurse bool) error {
+				tempdir, err := os.MkdirTemp("", "kutti-scp-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(tempdir)
+
+				err = scpDownload(sourceclient, sourcepath, tempdir, recurse)
+				if err != nil {
+					return err
+				}
+
+				entries, err := os.ReadDir(tempdir)
+				if err != nil {
+					return err
+				}
+				if len(entries) != 1 {
+					return fmt.Errorf("could not copy '%v' to the host", sourcepath)
+				}
+
+				return scpUpload(
+					targetclient,
+					filepath.Join(tempdir, entries[0].Name()),
+					targetpath,
+					recurse,
+				)
+			},
+		},
+		options,
+	)
+}
+
+// copytree is a copy of a file or directory from a source to a target.
+type copytree struct {
+	source     *endpoint
+	sourcepath string
+	target     *endpoint
+	targetpath string
+	// transfer copies a file, or a directory if recurse is set, with
+	// scp semantics for the target path.
+	transfer func(sourcepath string, targetpath string, recurse bool) error
+}
+
+func copyTree(ct *copytree, options *CopyOptions) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+
+	kind, sourceroot, err := ct.source.stat(ct.sourcepath)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case 'n':
+		return fmt.Errorf("'%v': no such file or directory", ct.sourcepath)
+	case 'd':
+		if !options.Recurse {
+			return fmt.Errorf("'%v' is a directory", ct.sourcepath)
+		}
+	}
+
+	// Without checks, scp does all the work
+	if !options.Resume && !options.Verify && options.Progress == nil {
+		return ct.transfer(ct.sourcepath, ct.targetpath, options.Recurse)
+	}
+
+	basename := ct.source.base(sourceroot)
+	if strings.Trim(basename, `/\`) == "" {
+		return fmt.Errorf("cannot copy '%v'", ct.sourcepath)
+	}
+
+	targetroot, err := targetRoot(ct.target, ct.targetpath, basename)
+	if err != nil {
+		return err
+	}
+
+	// The listing of the source is needed for the total size, and to
+	// know which files to check.
+	entries, err := ct.source.list(sourceroot)
+	if err != nil {
+		return fmt.Errorf("could not list source files: %v", err)
+	}
+
+	total := int64(0)
+	for _, size := range entries {
+		total += size
+	}
+
+	// Without resume, or if nothing could be skipped, everything is
+	// copied at once
+	copyall := true
+	if options.Resume {
+		skipped, err := ct.uptodate(sourceroot, targetroot, entries)
+		if err != nil {
+			return err
+		}
+
+		if len(skipped) > 0 {
+			kuttilog.Printf(kuttilog.Info, "Skipping %v files that are already up to date.", len(skipped))
+		}
+
+		for _, relpath := range skipped {
+			kuttilog.Printf(kuttilog.Verbose, "Skipped '%v'.", ct.source.join(sourceroot, relpath))
+			total -= entries[relpath]
+			delete(entries, relpath)
+		}
+
+		if len(entries) == 0 && len(skipped) > 0 {
+			if options.Progress != nil {
+				options.Progress(0, 0)
+			}
+			return nil
+		}
+
+		copyall = len(skipped) == 0
+	}
+
+	err = ct.watch(targetroot, total, options.Progress, func() error {
+		if copyall {
+			return ct.transfer(ct.sourcepath, ct.targetpath, options.Recurse)
+		}
+
+		return ct.transferFiles(sourceroot, targetroot, entries)
+	})
+	if err != nil {
+		return err
+	}
+
+	if options.Verify {
+		return ct.verify(sourceroot, targetroot, entries)
+	}
+
+	return nil
+}
+
+// targetRoot returns the path that the source will be copied as: inside
+// the target path if it is an existing directory, or the target path
+// itself.
+func targetRoot(target *endpoint, targetpath string, basename string) (string, error) {
+	isdir, err := target.isdir(targetpath)
+	if err != nil {
+		return "", err
+	}
+
+	if isdir {
+		return target.join(targetpath, basename), nil
+	}
+
+	return targetpath, nil
+}
+
+// transferFiles copies the listed files one by one, creating their
+// directories on the target first.
+func (ct *copytree) transferFiles(sourceroot string, targetroot string, entries map[string]int64) error {
+	relpaths := sortedPaths(entries)
+
+	dirs := []string{}
+	seen := map[string]bool{}
+	for _, relpath := range relpaths {
+		if relpath == "" {
+			continue
+		}
+
+		dir := path.Dir(relpath)
+		if dir == "." {
+			dir = ""
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	err := ct.target.mkdirs(targetroot, dirs)
+	if err != nil {
+		return fmt.Errorf("could not create target directories: %v", err)
+	}
+
+	for _, relpath := range relpaths {
+		err = ct.transfer(
+			ct.source.join(sourceroot, relpath),
+			ct.target.join(targetroot, relpath),
+			false,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watch runs a transfer. If progress is not nil, it is reported from the
+// growth of the target while the transfer runs.
+func (ct *copytree) watch(targetroot string, total int64, progress func(int64, int64), run func() error) error {
+	if progress == nil {
+		return run()
+	}
+
+	initial, _ := ct.target.size(targetroot)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				size, err := ct.target.size(targetroot)
+				if err != nil {
+					continue
+				}
+
+				// The count is capped just below the total until the
+				// copy is complete
+				progress(min(max(size-initial, 0), max(total-1, 0)), total)
+			}
+		}
+	}()
+
+	err := run()
+	close(done)
+	<-stopped
+
+	if err != nil {
+		return err
+	}
+
+	progress(total, total)
+	return nil
+}
+
+// sortedPaths returns the relative paths of a listing, sorted.
+func sortedPaths(entries map[string]int64) []string {
+	result := make([]string, 0, len(entries))
+	for relpath := range entries {
+		result = append(result, relpath)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// uptodate returns the source files that already exist on the target with
+// the same size and checksum.
+func (ct *copytree) uptodate(sourceroot string, targetroot string, entries map[string]int64) ([]string, error) {
+	targetentries, err := ct.target.list(targetroot)
+	if err != nil {
+		return nil, fmt.Errorf("could not list target files: %v", err)
+	}
+
+	// Only files of the same size are worth checksumming
+	candidates := []string{}
+	for _, relpath := range sortedPaths(entries) {
+		targetsize, ok := targetentries[relpath]
+		if ok && targetsize == entries[relpath] {
+			candidates = append(candidates, relpath)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sourcesums, targetsums, err := ct.checksums(sourceroot, targetroot, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, relpath := range candidates {
+		if sourcesums[relpath] != "" && sourcesums[relpath] == targetsums[relpath] {
+			result = append(result, relpath)
+		}
+	}
+
+	return result, nil
+}
+
+// verify compares the checksums of copied files on both sides.
+func (ct *copytree) verify(sourceroot string, targetroot string, entries map[string]int64) error {
+	relpaths := sortedPaths(entries)
+	if len(relpaths) == 0 {
+		return nil
+	}
+
+	kuttilog.Printf(kuttilog.Verbose, "Verifying %v files...", len(relpaths))
+	sourcesums, targetsums, err := ct.checksums(sourceroot, targetroot, relpaths)
+	if err != nil {
+		return err
+	}
+
+	mismatches := []string{}
+	for _, relpath := range relpaths {
+		if sourcesums[relpath] == "" || sourcesums[relpath] != targetsums[relpath] {
+			mismatches = append(mismatches, ct.target.join(targetroot, relpath))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf(
+			"checksum verification failed for %v files: %v",
+			len(mismatches),
+			strings.Join(mismatches, ", "),
+		)
+	}
+
+	kuttilog.Printf(kuttilog.Verbose, "Verified %v files.", len(relpaths))
+	return nil
+}
+
+func (ct *copytree) checksums(sourceroot string, targetroot string, relpaths []string) (map[string]string, map[string]string, error) {
+	sourcesums, err := ct.source.checksums(sourceroot, relpaths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not compute source checksums: %v", err)
+	}
+
+	targetsums, err := ct.target.checksums(targetroot, relpaths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not compute target checksums: %v", err)
+	}
+
+	return sourcesums, targetsums, nil
+}
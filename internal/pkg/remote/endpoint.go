@@ -0,0 +1,396 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/kuttiproject/kutti/internal/pkg/download"
+)
+
+// endpoint is one side of a copy: the host if client is nil, or a node.
+// Commands on nodes stick to POSIX utilities, plus sha256sum.
+type endpoint struct {
+	client *ssh.Client
+}
+
+// join appends a relative path, using forward slashes, to a root path.
+func (e *endpoint) join(root string, relpath string) string {
+	if relpath == "" {
+		return root
+	}
+
+	if e.client == nil {
+		return filepath.Join(root, filepath.FromSlash(relpath))
+	}
+
+	return path.Join(root, relpath)
+}
+
+// base returns the last element of a path.
+func (e *endpoint) base(p string) string {
+	if e.client == nil {
+		return filepath.Base(p)
+	}
+
+	return path.Base(p)
+}
+
+// run runs a command on the node, and returns its standard output.
+func (e *endpoint) run(command string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	exitstatus, err := runSession(e.client, command, nil, &stdout, &stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if exitstatus != 0 {
+		return "", fmt.Errorf(
+			"command exited with status %v: %v",
+			exitstatus,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	return stdout.String(), nil
+}
+
+// stat returns the kind of a path, 'd' for a directory, 'f' for anything
+// else, or 'n' if it does not exist, and the path resolved so that it has
+// a usable base name. Directories are resolved to their physical path.
+func (e *endpoint) stat(p string) (byte, string, error) {
+	if e.client == nil {
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			return 'n', p, nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+
+		abspath, err := filepath.Abs(p)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if fi.IsDir() {
+			return 'd', abspath, nil
+		}
+		return 'f', abspath, nil
+	}
+
+	output, err := e.run(fmt.Sprintf(
+		"if [ -d %[1]v ]; then echo d; cd %[1]v && pwd -P; "+
+			"elif [ -e %[1]v ]; then echo f; printf '%%s\\n' %[1]v; else echo n; fi",
+		remotePath(p),
+	))
+	if err != nil {
+		return 0, "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	switch {
+	case lines[0] == "n":
+		return 'n', p, nil
+	case len(lines) == 2 && (lines[0] == "d" || lines[0] == "f"):
+		return lines[0][0], lines[1], nil
+	default:
+		return 0, "", fmt.Errorf("could not stat '%v'", p)
+	}
+}
+
+// isdir reports whether a path is an existing directory.
+func (e *endpoint) isdir(p string) (bool, error) {
+	if e.client == nil {
+		fi, err := os.Stat(p)
+		return err == nil && fi.IsDir(), nil
+	}
+
+	exitstatus, err := runSession(e.client, "test -d "+remotePath(p), nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return exitstatus == 0, nil
+}
+
+// list returns the sizes of the regular files under a root path, which
+// may be a file or a directory, keyed by path relative to the root. A
+// root file has the empty path. If the root does not exist, the listing
+// is empty.
+func (e *endpoint) list(root string) (map[string]int64, error) {
+	result := map[string]int64{}
+
+	if e.client == nil {
+		_, err := os.Stat(root)
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		err = filepath.Walk(root, func(filename string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+
+			relpath, err := filepath.Rel(root, filename)
+			if err != nil {
+				return err
+			}
+			result[slashpath(relpath)] = fi.Size()
+
+			return nil
+		})
+
+		return result, err
+	}
+
+	// One line per file, with the size and the path relative to the
+	// root, which starts with ./ unless the root is a file.
+	output, err := e.run(fmt.Sprintf(
+		"if [ -d %[1]v ]; then cd %[1]v && find . -type f -exec sh -c "+
+			"'for f in \"$@\"; do printf \"%%s %%s\\n\" \"$(wc -c < \"$f\")\" \"$f\"; done' sh {} +; "+
+			"elif [ -f %[1]v ]; then printf '%%s \\n' \"$(wc -c < %[1]v)\"; fi",
+		remotePath(root),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		// wc pads the size with spaces on some systems
+		fields := strings.SplitN(strings.TrimLeft(line, " \t"), " ", 2)
+		if len(fields) < 2 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse file listing: %v", err)
+		}
+
+		result[strings.TrimPrefix(fields[1], "./")] = size
+	}
+
+	return result, nil
+}
+
+// size returns the total size of the files under a root path, or zero
+// if it does not exist. On nodes, the size is in whole kibibytes.
+func (e *endpoint) size(root string) (int64, error) {
+	if e.client == nil {
+		entries, err := e.list(root)
+		if err != nil {
+			return 0, err
+		}
+
+		total := int64(0)
+		for _, size := range entries {
+			total += size
+		}
+
+		return total, nil
+	}
+
+	output, err := e.run(fmt.Sprintf(
+		"if [ -e %[1]v ]; then du -sk %[1]v; fi",
+		remotePath(root),
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	kib, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse disk usage: %v", err)
+	}
+
+	return kib * 1024, nil
+}
+
+// mkdirs creates directories under a root path, with their parents.
+func (e *endpoint) mkdirs(root string, relpaths []string) error {
+	if e.client == nil {
+		for _, relpath := range relpaths {
+			err := os.MkdirAll(e.join(root, relpath), 0755)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// The command line of each batch is kept well below system limits
+	const batchsize = 100
+	for start := 0; start < len(relpaths); start += batchsize {
+		batch := relpaths[start:min(start+batchsize, len(relpaths))]
+
+		quoted := make([]string, len(batch))
+		for i, relpath := range batch {
+			quoted[i] = remotePath(e.join(root, relpath))
+		}
+
+		_, err := e.run("mkdir -p " + strings.Join(quoted, " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checksums returns the SHA-256 checksums of files under a root path,
+// keyed by relative path. Files that cannot be read are left out.
+func (e *endpoint) checksums(root string, relpaths []string) (map[string]string, error) {
+	result := map[string]string{}
+
+	if e.client == nil {
+		for _, relpath := range relpaths {
+			checksum, err := download.FileSHA256(e.join(root, relpath))
+			if err == nil {
+				result[relpath] = checksum
+			}
+		}
+
+		return result, nil
+	}
+
+	// The command line of each batch is kept well below system limits
+	const batchsize = 100
+	for start := 0; start < len(relpaths); start += batchsize {
+		batch := relpaths[start:min(start+batchsize, len(relpaths))]
+
+		quoted := make([]string, len(batch))
+		for i, relpath := range batch {
+			quoted[i] = remotePath(e.join(root, relpath))
+		}
+
+		// One line per file, in order. Reading from standard input
+		// keeps file names out of the output.
+		output, err := e.run(fmt.Sprintf(
+			"for f in %v; do sha256sum < \"$f\" 2>/dev/null || echo -; done",
+			strings.Join(quoted, " "),
+		))
+		if err != nil {
+			return nil, err
+		}
+
+		lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+		for i, relpath := range batch {
+			if i >= len(lines) {
+				break
+			}
+
+			fields := strings.Fields(lines[i])
+			if len(fields) > 0 && fields[0] != "-" {
+				result[relpath] = fields[0]
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// slashpath converts a relative path to forward slashes, with the empty
+// string for the path itself.
+func slashpath(relpath string) string {
+	if relpath == "." {
+		return ""
+	}
+
+	return filepath.ToSlash(relpath)
+}
+
+// HasGlob reports whether a path contains shell wildcards.
+func HasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// Glob expands a path pattern on the node at the specified address, and
+// returns the matching paths. A path without wildcards is returned as
+// is, whether it exists or not.
+func (c *Client) Glob(address string, pattern string) ([]string, error) {
+	if !HasGlob(pattern) {
+		return []string{pattern}, nil
+	}
+
+	results, err := c.RunWithResults(
+		address,
+		fmt.Sprintf(
+			"for f in %v; do if [ -e \"$f\" ] || [ -L \"$f\" ]; then printf '%%s\\n' \"$f\"; fi; done",
+			globPattern(pattern),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("'%v': no matches found", pattern)
+	}
+
+	return results, nil
+}
+
+// IsDir reports whether a path is an existing directory on the node at
+// the specified address.
+func (c *Client) IsDir(address string, remotepath string) (bool, error) {
+	exitstatus, err := c.Run(address, "test -d "+remotePath(remotepath), nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return exitstatus == 0, nil
+}
+
+// globPattern escapes a path pattern for the shell, except for wildcards
+// and a leading ~/.
+func globPattern(p string) string {
+	var result strings.Builder
+	if strings.HasPrefix(p, "~/") {
+		result.WriteString("~/")
+		p = p[2:]
+	}
+
+	for _, r := range p {
+		switch {
+		case strings.ContainsRune("*?[]", r):
+		case r < 128 && (r == '/' || r == '.' || r == '_' || r == '-' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')):
+		default:
+			result.WriteRune('\\')
+		}
+		result.WriteRune(r)
+	}
+
+	return result.String()
+}
+
+// remotePath quotes a remote path for the shell, leaving a leading ~/
+// unquoted so that it is expanded to the home directory.
+func remotePath(p string) string {
+	if p == "~" {
+		return "~"
+	}
+
+	if strings.HasPrefix(p, "~/") {
+		return "~/" + ShellQuote(p[2:])
+	}
+
+	return ShellQuote(p)
+}
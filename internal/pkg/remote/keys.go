@@ -0,0 +1,70 @@
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadKey reads an unencrypted private key in OpenSSH or PEM format.
+func LoadKey(keyfile string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(data)
+}
+
+// GenerateKey creates an ed25519 key pair. The private key is written to
+// the specified file in OpenSSH format, and the public key to the same
+// file name with a .pub extension, in authorized_keys format.
+func GenerateKey(keyfile string, comment string) error {
+	publickey, privatekey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	block, err := ssh.MarshalPrivateKey(privatekey, comment)
+	if err != nil {
+		return err
+	}
+
+	sshpublickey, err := ssh.NewPublicKey(publickey)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(keyfile), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(keyfile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		return err
+	}
+
+	authorizedkey := ssh.MarshalAuthorizedKey(sshpublickey)
+	authorizedkey = append(authorizedkey[:len(authorizedkey)-1], []byte(" "+comment+"\n")...)
+	return os.WriteFile(keyfile+".pub", authorizedkey, 0644)
+}
+
+// EnsureKey generates a key pair in the specified file, unless the file
+// already exists.
+func EnsureKey(keyfile string, comment string) error {
+	_, err := os.Stat(keyfile)
+	if err == nil {
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	return GenerateKey(keyfile, comment)
+}
@@ -0,0 +1,283 @@
+package remote
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	keyfile := filepath.Join(t.TempDir(), "keys", "id_ed25519")
+
+	err := EnsureKey(keyfile, "kutti-test")
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	signer, err := LoadKey(keyfile)
+	if err != nil {
+		t.Fatalf("could not load generated key: %v", err)
+	}
+	if signer.PublicKey().Type() != "ssh-ed25519" {
+		t.Fatalf("expected an ed25519 key, got %v", signer.PublicKey().Type())
+	}
+
+	data, _ := os.ReadFile(keyfile)
+
+	// A second call should not replace the key
+	err = EnsureKey(keyfile, "kutti-test")
+	if err != nil {
+		t.Fatalf("EnsureKey failed on existing key: %v", err)
+	}
+	newdata, _ := os.ReadFile(keyfile)
+	if !bytes.Equal(data, newdata) {
+		t.Fatalf("EnsureKey replaced an existing key")
+	}
+
+	_, err = os.Stat(keyfile + ".pub")
+	if err != nil {
+		t.Fatalf("public key not written: %v", err)
+	}
+}
+
+func TestCopyTreeResume(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source")
+	os.MkdirAll(filepath.Join(source, "sub"), 0755)
+	os.WriteFile(filepath.Join(source, "a.txt"), []byte("alpha"), 0644)
+	os.WriteFile(filepath.Join(source, "sub", "b.txt"), []byte("beta"), 0644)
+
+	// The target already has an up to date a.txt, and a stale b.txt
+	target := t.TempDir()
+	os.MkdirAll(filepath.Join(target, "source", "sub"), 0755)
+	os.WriteFile(filepath.Join(target, "source", "a.txt"), []byte("alpha"), 0644)
+	os.WriteFile(filepath.Join(target, "source", "sub", "b.txt"), []byte("bete"), 0644)
+
+	transferred := []string{}
+	ct := &copytree{
+		source:     &endpoint{},
+		sourcepath: source,
+		target:     &endpoint{},
+		targetpath: target,
+		transfer: func(sourcepath string, targetpath string, recurse bool) error {
+			transferred = append(transferred, sourcepath)
+			data, err := os.ReadFile(sourcepath)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(targetpath, data, 0644)
+		},
+	}
+
+	err := copyTree(ct, &CopyOptions{Recurse: true, Resume: true, Verify: true})
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+
+	if len(transferred) != 1 || transferred[0] != filepath.Join(source, "sub", "b.txt") {
+		t.Fatalf("expected only sub/b.txt to be copied, got %v", transferred)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(target, "source", "sub", "b.txt"))
+	if string(data) != "beta" {
+		t.Fatalf("expected sub/b.txt to be updated, got '%s'", data)
+	}
+}
+
+func TestSCPRoundTrip(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source")
+	os.MkdirAll(filepath.Join(source, "sub"), 0755)
+	os.WriteFile(filepath.Join(source, "a.txt"), []byte("alpha"), 0644)
+	os.WriteFile(filepath.Join(source, "sub", "b.txt"), []byte("beta"), 0644)
+
+	testCases := []struct {
+		sourcepath string
+		targetpath func(dir string) string
+		recurse    bool
+		expected   map[string]string
+	}{
+		{
+			sourcepath: filepath.Join(source, "a.txt"),
+			targetpath: func(dir string) string { return filepath.Join(dir, "renamed.txt") },
+			expected:   map[string]string{"renamed.txt": "alpha"},
+		},
+		{
+			sourcepath: filepath.Join(source, "a.txt"),
+			targetpath: func(dir string) string { return dir },
+			expected:   map[string]string{"a.txt": "alpha"},
+		},
+		{
+			sourcepath: source,
+			targetpath: func(dir string) string { return dir },
+			recurse:    true,
+			expected: map[string]string{
+				"source/a.txt":     "alpha",
+				"source/sub/b.txt": "beta",
+			},
+		},
+		{
+			sourcepath: source,
+			targetpath: func(dir string) string { return filepath.Join(dir, "copy") },
+			recurse:    true,
+			expected: map[string]string{
+				"copy/a.txt":     "alpha",
+				"copy/sub/b.txt": "beta",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		target := t.TempDir()
+
+		// The two sides talk over a pair of pipes, as they would over
+		// an SSH session
+		sendr, sendw := io.Pipe()
+		ackr, ackw := io.Pipe()
+
+		received := make(chan error, 1)
+		go func() {
+			err := scpReceive(sendr, ackw, tc.targetpath(target), tc.recurse)
+			sendr.CloseWithError(io.ErrClosedPipe)
+			ackw.Close()
+			received <- err
+		}()
+
+		err := scpSend(ackr, sendw, tc.sourcepath, tc.recurse)
+		sendw.Close()
+		if err != nil {
+			t.Fatalf("sending '%v' failed: %v", tc.sourcepath, err)
+		}
+		err = <-received
+		if err != nil {
+			t.Fatalf("receiving '%v' failed: %v", tc.sourcepath, err)
+		}
+
+		for relpath, content := range tc.expected {
+			data, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(relpath)))
+			if err != nil || string(data) != content {
+				t.Fatalf("copying '%v': expected %v to contain '%v', got '%s' (%v)", tc.sourcepath, relpath, content, data, err)
+			}
+		}
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		expected string
+	}{
+		{pattern: "/var/log/*.log", expected: "/var/log/*.log"},
+		{pattern: "~/logs/[ab]?.log", expected: "~/logs/[ab]?.log"},
+		{pattern: "my logs/*", expected: `my\ logs/*`},
+		{pattern: "x;rm *", expected: `x\;rm\ *`},
+		{pattern: "a~/*", expected: `a\~/*`},
+	}
+
+	for _, tc := range testCases {
+		result := globPattern(tc.pattern)
+		if result != tc.expected {
+			t.Fatalf("pattern '%v': expected '%v', got '%v'", tc.pattern, tc.expected, result)
+		}
+	}
+}
+
+func TestParseForward(t *testing.T) {
+	testCases := []struct {
+		spec   string
+		local  string
+		remote string
+		valid  bool
+	}{
+		{spec: "8080:localhost:80", local: "localhost:8080", remote: "localhost:80", valid: true},
+		{spec: "0.0.0.0:8443:10.96.0.1:443", local: "0.0.0.0:8443", remote: "10.96.0.1:443", valid: true},
+		{spec: "[::1]:8080:[fd00::1]:80", local: "[::1]:8080", remote: "[fd00::1]:80", valid: true},
+		{spec: "8080:localhost", valid: false},
+		{spec: "8080::80", valid: false},
+		{spec: "0:localhost:80", valid: false},
+		{spec: "8080:localhost:http", valid: false},
+		{spec: "[::1:8080:localhost:80", valid: false},
+	}
+
+	for _, tc := range testCases {
+		result, err := ParseForward(tc.spec)
+		if !tc.valid {
+			if err == nil {
+				t.Fatalf("spec '%v': expected error, got %v", tc.spec, result)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("spec '%v': %v", tc.spec, err)
+		}
+		if result.LocalAddress != tc.local || result.RemoteAddress != tc.remote {
+			t.Fatalf("spec '%v': expected %v -> %v, got %v -> %v", tc.spec, tc.local, tc.remote, result.LocalAddress, result.RemoteAddress)
+		}
+	}
+}
+
+func TestSOCKSHandshake(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  []byte
+		expected string
+		reply    []byte
+	}{
+		{
+			name:     "domain",
+			request:  []byte{5, 1, 0, 5, 1, 0, 3, 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0, 80},
+			expected: "localhost:80",
+			reply:    []byte{5, 0},
+		},
+		{
+			name:     "ipv4",
+			request:  []byte{5, 2, 2, 0, 5, 1, 0, 1, 10, 96, 0, 1, 1, 187},
+			expected: "10.96.0.1:443",
+			reply:    []byte{5, 0},
+		},
+		{
+			name:    "auth only",
+			request: []byte{5, 1, 2},
+			reply:   []byte{5, 0xff},
+		},
+		{
+			name:    "bind",
+			request: []byte{5, 1, 0, 5, 2, 0, 1, 10, 96, 0, 1, 1, 187},
+			reply:   []byte{5, 0, 5, 7, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		var reply bytes.Buffer
+		result, err := socksHandshake(struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(tc.request), &reply})
+		if tc.expected == "" {
+			if err == nil {
+				t.Fatalf("%v: expected error, got %v", tc.name, result)
+			}
+		} else if err != nil || result != tc.expected {
+			t.Fatalf("%v: expected %v, got %v (%v)", tc.name, tc.expected, result, err)
+		}
+
+		if !bytes.Equal(reply.Bytes(), tc.reply) {
+			t.Fatalf("%v: expected reply %v, got %v", tc.name, tc.reply, reply.Bytes())
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	client := NewWithPassword("user1", "it's secret")
+
+	command := client.Sudo("kubeadm init")
+	redacted := client.Redact(command)
+	if strings.Contains(redacted, "secret") {
+		t.Fatalf("password not redacted: %v", redacted)
+	}
+	if !strings.Contains(redacted, "kubeadm init") {
+		t.Fatalf("command lost in redaction: %v", redacted)
+	}
+}
@@ -0,0 +1,369 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Files are copied with the scp protocol, run over a connection that is
+// already authenticated, so that copies work with keys as well as with
+// passwords. The node runs scp as the other side of the copy: "scp -t"
+// to receive files, and "scp -f" to send them.
+
+// scpUpload copies a local file, or a directory if recurse is set, to a
+// node. The remote path is treated as scp treats it.
+func scpUpload(client *ssh.Client, localpath string, remotepath string, recurse bool) error {
+	return scpSession(
+		client,
+		scpCommand("-t", remotepath, recurse),
+		func(r io.Reader, w io.Writer) error {
+			return scpSend(r, w, localpath, recurse)
+		},
+	)
+}
+
+// scpDownload copies a file, or a directory if recurse is set, from a node
+// to a local path. If the local path is an existing directory, the source
+// is copied into it; otherwise, the source is copied as the local path.
+func scpDownload(client *ssh.Client, remotepath string, localpath string, recurse bool) error {
+	return scpSession(
+		client,
+		scpCommand("-f", remotepath, recurse),
+		func(r io.Reader, w io.Writer) error {
+			return scpReceive(r, w, localpath, recurse)
+		},
+	)
+}
+
+func scpCommand(mode string, remotepath string, recurse bool) string {
+	if recurse {
+		return "scp -r " + mode + " " + remotePath(remotepath)
+	}
+
+	return "scp " + mode + " " + remotePath(remotepath)
+}
+
+// scpSession runs scp on the node, and the local side of the copy against
+// its standard output and input.
+func scpSession(client *ssh.Client, command string, local func(r io.Reader, w io.Writer) error) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	err = session.Start(command)
+	if err != nil {
+		return err
+	}
+
+	err = local(stdout, stdin)
+	stdin.Close()
+	if err != nil {
+		return err
+	}
+
+	err = session.Wait()
+	var exiterr *ssh.ExitError
+	if errors.As(err, &exiterr) {
+		return fmt.Errorf(
+			"scp exited with status %v: %v",
+			exiterr.ExitStatus(),
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	return err
+}
+
+// readAck reads the response of the other side to a protocol message.
+func readAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		message, _ := r.ReadString('\n')
+		return errors.New(strings.TrimSpace(message))
+	default:
+		return fmt.Errorf("unexpected scp response %q", b)
+	}
+}
+
+// scpSend is the sending side of an scp copy. It reads responses from r,
+// and writes messages and file contents to w.
+func scpSend(r io.Reader, w io.Writer, localpath string, recurse bool) error {
+	br := bufio.NewReader(r)
+
+	abspath, err := filepath.Abs(localpath)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(abspath)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() && !recurse {
+		return fmt.Errorf("'%v' is a directory", localpath)
+	}
+
+	// The receiving side speaks first
+	err = readAck(br)
+	if err != nil {
+		return err
+	}
+
+	return sendEntry(br, w, abspath, fi)
+}
+
+func sendEntry(br *bufio.Reader, w io.Writer, localpath string, fi os.FileInfo) error {
+	if !fi.IsDir() {
+		return sendFile(br, w, localpath, fi)
+	}
+
+	_, err := fmt.Fprintf(w, "D%04o 0 %s\n", fi.Mode().Perm(), fi.Name())
+	if err != nil {
+		return err
+	}
+
+	err = readAck(br)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(localpath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entrypath := filepath.Join(localpath, entry.Name())
+
+		// Like scp, links are followed
+		entryinfo, err := os.Stat(entrypath)
+		if err != nil {
+			return err
+		}
+
+		err = sendEntry(br, w, entrypath, entryinfo)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "E\n")
+	if err != nil {
+		return err
+	}
+
+	return readAck(br)
+}
+
+func sendFile(br *bufio.Reader, w io.Writer, localpath string, fi os.FileInfo) error {
+	f, err := os.Open(localpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(w, "C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), fi.Name())
+	if err != nil {
+		return err
+	}
+
+	err = readAck(br)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(w, f, fi.Size())
+	if err != nil {
+		return fmt.Errorf("could not send '%v': %v", localpath, err)
+	}
+
+	_, err = w.Write([]byte{0})
+	if err != nil {
+		return err
+	}
+
+	return readAck(br)
+}
+
+// scpReceive is the receiving side of an scp copy. It reads messages and
+// file contents from r, and writes responses to w.
+func scpReceive(r io.Reader, w io.Writer, localpath string, recurse bool) error {
+	br := bufio.NewReader(r)
+	ack := func() error {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+
+	intodir := false
+	fi, err := os.Stat(localpath)
+	if err == nil {
+		intodir = fi.IsDir()
+	}
+
+	// dirs holds the directories being received, innermost last
+	dirs := []string{}
+
+	err = ack()
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch line[0] {
+		case 1, 2:
+			return errors.New(strings.TrimSpace(line[1:]))
+		case 'T':
+			// Times are not preserved
+			err = ack()
+		case 'E':
+			if len(dirs) == 0 {
+				return errors.New("unexpected end of directory in scp stream")
+			}
+			dirs = dirs[:len(dirs)-1]
+			err = ack()
+		case 'C', 'D':
+			mode, size, name, perr := parseSCPEntry(line[1:])
+			if perr != nil {
+				return perr
+			}
+
+			targetpath := localpath
+			switch {
+			case len(dirs) > 0:
+				targetpath = filepath.Join(dirs[len(dirs)-1], name)
+			case intodir:
+				targetpath = filepath.Join(localpath, name)
+			}
+
+			if line[0] == 'D' {
+				if !recurse {
+					return fmt.Errorf("unexpected directory '%v' in scp stream", name)
+				}
+
+				err = os.Mkdir(targetpath, mode|0700)
+				if os.IsExist(err) {
+					err = nil
+				}
+				if err != nil {
+					return err
+				}
+
+				dirs = append(dirs, targetpath)
+				err = ack()
+				break
+			}
+
+			err = receiveFile(br, ack, targetpath, mode, size)
+		default:
+			return fmt.Errorf("unexpected scp message %q", line)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(dirs) > 0 {
+		return errors.New("scp stream ended inside a directory")
+	}
+
+	return nil
+}
+
+func receiveFile(br *bufio.Reader, ack func() error, targetpath string, mode os.FileMode, size int64) error {
+	f, err := os.OpenFile(targetpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = ack()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(f, br, size)
+	if err != nil {
+		return fmt.Errorf("could not receive '%v': %v", targetpath, err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = readAck(br)
+	if err != nil {
+		return err
+	}
+
+	return ack()
+}
+
+// parseSCPEntry parses the mode, size and name of a file or directory
+// message, without its leading letter.
+func parseSCPEntry(s string) (os.FileMode, int64, string, error) {
+	fields := strings.SplitN(s, " ", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("malformed scp message %q", s)
+	}
+
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("malformed scp mode %q", fields[0])
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("malformed scp size %q", fields[1])
+	}
+
+	// A name that is not a single path element could escape the target
+	name := fields[2]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return 0, 0, "", fmt.Errorf("invalid name %q in scp stream", name)
+	}
+
+	return os.FileMode(mode).Perm(), size, name, nil
+}
@@ -0,0 +1,85 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// RunInteractiveShell opens a shell at the specified address, connected
+// to the standard input, output and error of the current process. If
+// standard input is a terminal, it is put in raw mode, and the remote
+// terminal follows its size.
+func (c *Client) RunInteractiveShell(address string) error {
+	client, err := c.Dial(address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+
+		width, height, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+
+		termtype := os.Getenv("TERM")
+		if termtype == "" {
+			termtype = "xterm-256color"
+		}
+
+		err = session.RequestPty(termtype, height, width, ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		})
+		if err != nil {
+			return err
+		}
+
+		stop := watchTerminalSize(session, int(os.Stdout.Fd()))
+		defer stop()
+	}
+
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	stdinpipe, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(stdinpipe, os.Stdin)
+		stdinpipe.Close()
+	}()
+
+	err = session.Shell()
+	if err != nil {
+		return err
+	}
+
+	// The exit status of the last command in the shell is not an error
+	err = session.Wait()
+	var exiterr *ssh.ExitError
+	if errors.As(err, &exiterr) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package remote
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchTerminalSize sends a window change to the session whenever the
+// local terminal is resized. The returned function stops watching.
+func watchTerminalSize(session *ssh.Session, fd int) func() {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-resized:
+				width, height, err := term.GetSize(fd)
+				if err == nil {
+					session.WindowChange(height, width)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(resized)
+		close(done)
+	}
+}
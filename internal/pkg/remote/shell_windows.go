@@ -0,0 +1,40 @@
+//go:build windows
+
+package remote
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchTerminalSize sends a window change to the session whenever the
+// local console is resized. Windows has no resize signal, so the size
+// is polled. The returned function stops watching.
+func watchTerminalSize(session *ssh.Session, fd int) func() {
+	width, height, _ := term.GetSize(fd)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				newwidth, newheight, err := term.GetSize(fd)
+				if err == nil && (newwidth != width || newheight != height) {
+					width, height = newwidth, newheight
+					session.WindowChange(height, width)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
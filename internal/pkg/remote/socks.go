@@ -0,0 +1,123 @@
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// A minimal SOCKS5 server (RFC 1928): no authentication, CONNECT only.
+// The destination is resolved and connected to from the node.
+
+const (
+	socksVersion        = 5
+	socksNoAuth         = 0
+	socksNoAcceptable   = 0xff
+	socksConnect        = 1
+	socksIPv4           = 1
+	socksDomain         = 3
+	socksIPv6           = 4
+	socksSucceeded      = 0
+	socksGeneralFailure = 1
+	socksNotSupported   = 7
+)
+
+// socksHandshake reads a SOCKS5 greeting and CONNECT request from a client
+// connection, and returns the requested destination address. Requests that
+// cannot be served are answered with a failure reply.
+func socksHandshake(conn io.ReadWriter) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %v", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	noauth := false
+	for _, method := range methods {
+		if method == socksNoAuth {
+			noauth = true
+			break
+		}
+	}
+	if !noauth {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", errors.New("client requires SOCKS authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %v", request[0])
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		size := net.IPv4len
+		if request[3] == socksIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksWriteReply(conn, socksNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %v", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	if request[1] != socksConnect {
+		socksWriteReply(conn, socksNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %v", request[1])
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply tells a SOCKS client whether its destination could be
+// connected to.
+func socksReply(conn io.Writer, err error) error {
+	if err != nil {
+		return socksWriteReply(conn, socksGeneralFailure)
+	}
+
+	return socksWriteReply(conn, socksSucceeded)
+}
+
+func socksWriteReply(conn io.Writer, status byte) error {
+	// The bound address is not meaningful through a tunnel, so it is
+	// always reported as 0.0.0.0:0.
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,287 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kuttiproject/kuttilog"
+	"golang.org/x/crypto/ssh"
+)
+
+// Forward is a local port forward: connections accepted at the local
+// address are connected, from the node, to the remote address.
+type Forward struct {
+	LocalAddress  string
+	RemoteAddress string
+}
+
+// ParseForward parses a forward in the format used by ssh -L, that is
+// [BINDADDRESS:]PORT:HOST:HOSTPORT. The default bind address is localhost.
+// IPv6 addresses may be enclosed in square brackets.
+func ParseForward(spec string) (*Forward, error) {
+	parts, err := splitForward(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	bindaddress := "localhost"
+	switch len(parts) {
+	case 3:
+	case 4:
+		bindaddress = parts[0]
+		parts = parts[1:]
+	default:
+		return nil, fmt.Errorf("invalid forward '%v': expected [BINDADDRESS:]PORT:HOST:HOSTPORT", spec)
+	}
+
+	localport, err := parsePort(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid forward '%v': %v", spec, err)
+	}
+	if parts[1] == "" {
+		return nil, fmt.Errorf("invalid forward '%v': no host specified", spec)
+	}
+	remoteport, err := parsePort(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid forward '%v': %v", spec, err)
+	}
+
+	return &Forward{
+		LocalAddress:  net.JoinHostPort(bindaddress, localport),
+		RemoteAddress: net.JoinHostPort(parts[1], remoteport),
+	}, nil
+}
+
+// ParseDynamicForward parses a SOCKS proxy address in the format used by
+// ssh -D, that is [BINDADDRESS:]PORT. The default bind address is localhost.
+func ParseDynamicForward(spec string) (string, error) {
+	parts, err := splitForward(spec)
+	if err != nil {
+		return "", err
+	}
+
+	bindaddress := "localhost"
+	switch len(parts) {
+	case 1:
+	case 2:
+		bindaddress = parts[0]
+		parts = parts[1:]
+	default:
+		return "", fmt.Errorf("invalid proxy address '%v': expected [BINDADDRESS:]PORT", spec)
+	}
+
+	port, err := parsePort(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid proxy address '%v': %v", spec, err)
+	}
+
+	return net.JoinHostPort(bindaddress, port), nil
+}
+
+// splitForward splits a forward specification at colons, treating
+// anything in square brackets as a single part.
+func splitForward(spec string) ([]string, error) {
+	var parts []string
+	for spec != "" {
+		if spec[0] == '[' {
+			end := strings.IndexByte(spec, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid address '%v': missing ']'", spec)
+			}
+			parts = append(parts, spec[1:end])
+			spec = spec[end+1:]
+			if spec != "" && spec[0] != ':' {
+				return nil, fmt.Errorf("invalid address '%v': expected ':' after ']'", spec)
+			}
+		} else {
+			end := strings.IndexByte(spec, ':')
+			if end == -1 {
+				end = len(spec)
+			}
+			parts = append(parts, spec[:end])
+			spec = spec[end:]
+		}
+
+		spec = strings.TrimPrefix(spec, ":")
+	}
+
+	return parts, nil
+}
+
+func parsePort(s string) (string, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid port '%v'", s)
+	}
+
+	return strconv.Itoa(port), nil
+}
+
+// Tunnel is an SSH connection to a node carrying port forwards and SOCKS
+// proxies. It runs until it is closed, or the connection is lost.
+type Tunnel struct {
+	client    *ssh.Client
+	listeners []net.Listener
+	closeonce sync.Once
+	closed    chan struct{}
+}
+
+// OpenTunnel connects to the specified address, and starts listening
+// for the specified forwards and SOCKS5 proxies. If any local address
+// cannot be listened on, nothing is opened.
+func (c *Client) OpenTunnel(address string, forwards []*Forward, proxies []string) (*Tunnel, error) {
+	client, err := c.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{
+		client: client,
+		closed: make(chan struct{}),
+	}
+
+	for _, forward := range forwards {
+		listener, err := net.Listen("tcp", forward.LocalAddress)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.listeners = append(t.listeners, listener)
+
+		go t.serve(listener, forward.RemoteAddress)
+	}
+
+	for _, proxy := range proxies {
+		listener, err := net.Listen("tcp", proxy)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.listeners = append(t.listeners, listener)
+
+		go t.serve(listener, "")
+	}
+
+	return t, nil
+}
+
+// Addresses returns the local addresses the tunnel is listening on, in
+// the order in which forwards and then proxies were specified.
+func (t *Tunnel) Addresses() []string {
+	result := make([]string, len(t.listeners))
+	for i, listener := range t.listeners {
+		result[i] = listener.Addr().String()
+	}
+
+	return result
+}
+
+// Wait blocks until the tunnel is closed, or the connection to the node
+// is lost. In the latter case, it returns an error.
+func (t *Tunnel) Wait() error {
+	lost := make(chan error, 1)
+	go func() {
+		lost <- t.client.Wait()
+	}()
+
+	select {
+	case <-t.closed:
+		return nil
+	case err := <-lost:
+		select {
+		case <-t.closed:
+			return nil
+		default:
+		}
+
+		t.Close()
+		if err == nil {
+			err = io.EOF
+		}
+		return fmt.Errorf("connection lost: %v", err)
+	}
+}
+
+// Close stops listening, and closes the connection to the node.
+func (t *Tunnel) Close() error {
+	var err error
+	t.closeonce.Do(func() {
+		close(t.closed)
+		for _, listener := range t.listeners {
+			listener.Close()
+		}
+		err = t.client.Close()
+	})
+
+	return err
+}
+
+// serve accepts connections on a listener until it is closed, and
+// connects them from the node to the remote address. If the remote
+// address is empty, each connection is a SOCKS5 client, which asks
+// for its own.
+func (t *Tunnel) serve(listener net.Listener, remoteaddress string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var neterr net.Error
+			if errors.As(err, &neterr) && neterr.Timeout() {
+				continue
+			}
+			return
+		}
+
+		go t.forward(conn, remoteaddress)
+	}
+}
+
+func (t *Tunnel) forward(conn net.Conn, address string) {
+	defer conn.Close()
+
+	socks := address == ""
+	if socks {
+		var err error
+		address, err = socksHandshake(conn)
+		if err != nil {
+			kuttilog.Printf(kuttilog.Verbose, "Rejected SOCKS connection from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	remoteconn, err := t.client.Dial("tcp", address)
+	if socks {
+		socksReply(conn, err)
+	}
+	if err != nil {
+		kuttilog.Printf(kuttilog.Verbose, "Could not connect to %v: %v", address, err)
+		return
+	}
+	defer remoteconn.Close()
+
+	kuttilog.Printf(kuttilog.Debug, "Forwarding %v to %v", conn.RemoteAddr(), address)
+
+	done := make(chan struct{}, 2)
+	go pipe(remoteconn, conn, done)
+	go pipe(conn, remoteconn, done)
+	<-done
+	<-done
+}
+
+// pipe copies from src to dst until src is exhausted, and then closes the
+// write side of dst, so that the other direction can still finish.
+func pipe(dst net.Conn, src net.Conn, done chan<- struct{}) {
+	io.Copy(dst, src)
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+
+	done <- struct{}{}
+}